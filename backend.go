@@ -0,0 +1,230 @@
+package burrow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+)
+
+// Backend invokes a Burrow function on a serverless platform. A Transport
+// serializes each request to a JSON Request (a BatchTransport to a JSON
+// BatchRequest), invokes the function with it through the backend, and
+// deserializes the JSON Response or BatchResponse that is returned.
+// Implementations adapt the payload to the invocation conventions of the
+// platform and return errors reported by the function as a *ProxyError.
+type Backend interface {
+	// Invoke sends the payload to the function and returns its reply
+	Invoke(ctx context.Context, payload []byte) ([]byte, error)
+	// URL identifies the function in metadata, metrics and traces
+	URL() string
+}
+
+// TokenSource returns a bearer token used to authorize a request, e.g. a
+// Google ID token. It is called for every request so it should cache tokens.
+type TokenSource func(ctx context.Context) (string, error)
+
+// HTTPBackend invokes a function that accepts the payload as the body of an
+// HTTP request and replies with the response as the body. This is how Lambda
+// Function URLs work, and how functions served by an HTTPHandler or an
+// AzureHandler are invoked on other platforms.
+type HTTPBackend struct {
+	url    string
+	method string
+	client *http.Client
+	header http.Header
+	tokens TokenSource
+	signer *sigV4Signer
+	// err is a configuration error returned by every invocation
+	err error
+}
+
+// NewHTTPBackend creates a backend that POSTs payloads to the URL.
+func NewHTTPBackend(url string) *HTTPBackend {
+	return &HTTPBackend{
+		url:    url,
+		method: "POST",
+		client: &http.Client{},
+		header: http.Header{},
+	}
+}
+
+// NewCloudFunctionBackend creates a backend for a Google Cloud Function or
+// Cloud Run service that serves an HTTPHandler. Unless the function allows
+// unauthenticated invocations, tokens must provide ID tokens with the
+// function URL as the audience, e.g. from google.golang.org/api/idtoken.
+func NewCloudFunctionBackend(url string, tokens TokenSource) *HTTPBackend {
+	return NewHTTPBackend(url).WithBearerToken(tokens)
+}
+
+// NewAzureFunctionBackend creates a backend for an Azure Function with an
+// HTTP trigger that is served by an AzureHandler. If key is not empty it is
+// sent as the function key, as required by the "function" auth level.
+func NewAzureFunctionBackend(url, key string) *HTTPBackend {
+	b := NewHTTPBackend(url)
+	if key != "" {
+		b.WithHeader("X-Functions-Key", key)
+	}
+	return b
+}
+
+// WithClient sets the HTTP client used to invoke the function
+func (b *HTTPBackend) WithClient(client *http.Client) *HTTPBackend {
+	b.client = client
+	return b
+}
+
+// WithMethod sets the HTTP method used to invoke the function
+func (b *HTTPBackend) WithMethod(method string) *HTTPBackend {
+	b.method = method
+	return b
+}
+
+// WithHeader sets a header sent with every invocation, e.g. an API key
+func (b *HTTPBackend) WithHeader(key, value string) *HTTPBackend {
+	b.header.Set(key, value)
+	return b
+}
+
+// WithBearerToken authorizes invocations with a bearer token from the source
+func (b *HTTPBackend) WithBearerToken(tokens TokenSource) *HTTPBackend {
+	b.tokens = tokens
+	return b
+}
+
+// WithSigV4 signs invocations with SigV4 using the provided credentials, or
+// DefaultCredentials if nil. Use this with Function URLs that use the AWS_IAM
+// auth type. If region is empty it is taken from the Function URL; if the URL
+// is not a Function URL, every invocation fails until a region is given.
+func (b *HTTPBackend) WithSigV4(region string, credentials CredentialsProvider) *HTTPBackend {
+	if credentials == nil {
+		credentials = DefaultCredentials()
+	}
+	if region == "" {
+		region = regionOf(b.url, "")
+	}
+	b.err = nil
+	if region == "" {
+		b.err = fmt.Errorf("sigv4 requires a region for proxy url %s", b.url)
+	}
+	b.signer = newSigV4Signer(credentials, region, lambdaService)
+	return b
+}
+
+// URL returns the function URL
+func (b *HTTPBackend) URL() string {
+	return b.url
+}
+
+// Invoke implements the Backend interface
+func (b *HTTPBackend) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	req, err := http.NewRequestWithContext(ctx, b.method, b.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy request: %w", err)
+	}
+	for key, values := range b.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.tokens != nil {
+		token, err := b.tokens(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if b.signer != nil {
+		if err := b.signer.sign(ctx, req, payload); err != nil {
+			return nil, err
+		}
+	}
+	resp, body, err := doProxyRequest(b.client, req)
+	if err != nil {
+		return nil, err
+	}
+	return proxyReply(resp.StatusCode, body)
+}
+
+// invocationError wraps an error from a platform, recording whether the
+// function was invoked and so is billed. The function runs once the request
+// has been sent, even if the client then gives up waiting for the reply.
+type invocationError struct {
+	err     error
+	invoked bool
+}
+
+func (e *invocationError) Error() string { return e.err.Error() }
+
+func (e *invocationError) Unwrap() error { return e.err }
+
+// wasInvoked reports whether the function ran for an attempt that failed
+// with err. Replies from the function, including errors and malformed
+// replies, mean it ran. Other errors mean it didn't, unless they are an
+// invocationError that says otherwise.
+func wasInvoked(err error) bool {
+	var invocationErr *invocationError
+	if errors.As(err, &invocationErr) {
+		return invocationErr.invoked
+	}
+	switch ClassifyError(err) {
+	case ErrorClassNone, ErrorClassProxy, ErrorClassProtocol:
+		return true
+	}
+	return false
+}
+
+// doProxyRequest sends a request to a function and reads the response body.
+func doProxyRequest(client *http.Client, req *http.Request) (*http.Response, []byte, error) {
+	var sent atomic.Bool
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				sent.Store(true)
+			}
+		},
+	}))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, &invocationError{
+			err:     fmt.Errorf("failed to send request to proxy: %w", err),
+			invoked: sent.Load(),
+		}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, &invocationError{
+			err:     fmt.Errorf("failed to read proxy response body: %w", err),
+			invoked: true,
+		}
+	}
+	return resp, body, nil
+}
+
+// proxyReply returns the body of a successful reply from a function. Non-200
+// replies are returned as a ProxyError. Status 429 comes from the Function
+// URL itself rather than the function and is reported as ProxyErrThrottled.
+func proxyReply(statusCode int, body []byte) ([]byte, error) {
+	if statusCode == http.StatusTooManyRequests {
+		return body, &invocationError{err: ProxyErrorf(ProxyErrThrottled, "proxy returned status %d", statusCode)}
+	}
+	if statusCode != http.StatusOK {
+		var errResp ProxyError
+		if err := json.Unmarshal(body, &errResp); err != nil {
+			return body, &ProxyError{
+				Message: fmt.Sprintf("proxy returned non-200 status code: %d", statusCode),
+				Type:    ProxyErrUnknown,
+			}
+		}
+		return body, &errResp
+	}
+	return body, nil
+}
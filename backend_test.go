@@ -0,0 +1,232 @@
+package burrow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOrigin(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Header().Set("X-Method", r.Method)
+		w.Write(append([]byte("hello "), body...))
+	}))
+}
+
+func TestCloudFunctionBackend(t *testing.T) {
+	origin := newOrigin(t)
+	defer origin.Close()
+
+	handler := NewHTTPHandler(GetHandler()).WithProxyName("gcp.functions.us-central1")
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer id-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("<html>Unauthorized</html>"))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer function.Close()
+
+	tokens := func(ctx context.Context) (string, error) { return "id-token", nil }
+	client := NewClient(WithBackends(NewCloudFunctionBackend(function.URL, tokens)))
+	client.Timeout = 10 * time.Second
+
+	resp, err := client.Post(origin.URL, "text/plain", strings.NewReader("world"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "POST", resp.Header.Get("X-Method"))
+	md := ResponseMetadata(resp)
+	require.NotNil(t, md)
+	assert.Equal(t, "gcp.functions.us-central1", md.ProxyName)
+	assert.Equal(t, function.URL, md.ProxyURL)
+	assert.Equal(t, EnvironmentID(), md.EnvironmentID)
+
+	unauthorized := NewClient(WithBackends(NewHTTPBackend(function.URL)))
+	_, err = unauthorized.Get(origin.URL)
+	assert.ErrorContains(t, err, "proxy error [0] proxy returned non-200 status code: 401")
+}
+
+func TestHTTPHandler_Errors(t *testing.T) {
+	handler := NewHTTPHandler(GetHandler())
+	tests := []struct {
+		method       string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"GET", "", 405, `{"message":"method not allowed"}`},
+		{"POST", "not json", 400, `{"message":"invalid request body (expected json)"}`},
+		{"POST", `{"method":"GET"}`, 400, `{"message":"url is required","type":1}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)))
+		assert.Equal(t, tt.expectedCode, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, tt.expectedBody, w.Body.String())
+	}
+}
+
+func TestHTTPHandler_Batch(t *testing.T) {
+	origin := newOrigin(t)
+	defer origin.Close()
+
+	handler := NewHTTPHandler(GetHandler()).WithProxyName("local")
+	payload, err := json.Marshal(BatchRequest{Requests: []*Request{
+		{URL: origin.URL, Method: "GET"},
+		{Method: "GET"},
+	}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/", bytes.NewReader(payload)))
+	require.Equal(t, 200, w.Code)
+
+	var batch BatchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &batch))
+	require.Len(t, batch.Results, 2)
+	require.NotNil(t, batch.Results[0].Response)
+	assert.Equal(t, "local", batch.Results[0].Response.ProxyName)
+	assert.Equal(t, "192.0.2.1", batch.Results[0].Response.ClientDetails.SourceIP)
+	require.NotNil(t, batch.Results[1].Error)
+	assert.Equal(t, ProxyErrBadRequest, batch.Results[1].Error.Type)
+}
+
+// newAzureHost returns a stand-in for the Azure Functions host, which
+// forwards HTTP trigger invocations to a custom handler.
+func newAzureHost(t *testing.T, customHandler http.Handler) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Functions-Key") != "function-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		headers := map[string][]string{}
+		for k, v := range r.Header {
+			headers[k] = v
+		}
+		headers["X-Forwarded-For"] = []string{"203.0.113.9:51234"}
+		invocation, err := json.Marshal(map[string]any{
+			"Data": map[string]any{
+				"req": map[string]any{
+					"Url":     "http://" + r.Host + r.URL.Path,
+					"Method":  r.Method,
+					"Query":   map[string]string{},
+					"Headers": headers,
+					"Params":  map[string]string{},
+					"Body":    string(body),
+				},
+			},
+			"Metadata": map[string]any{},
+		})
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		customHandler.ServeHTTP(rec, httptest.NewRequest("POST", "/burrow", bytes.NewReader(invocation)))
+		require.Equal(t, 200, rec.Code)
+		var result azureInvocationResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		for k, v := range result.Outputs.Res.Headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(result.Outputs.Res.StatusCode)
+		w.Write([]byte(result.Outputs.Res.Body))
+	}))
+}
+
+func TestAzureFunctionBackend(t *testing.T) {
+	origin := newOrigin(t)
+	defer origin.Close()
+	host := newAzureHost(t, NewAzureHandler(GetHandler()).WithProxyName("azure.functions.eastus"))
+	defer host.Close()
+
+	transport := NewTransportWithBackend(NewAzureFunctionBackend(host.URL+"/api/burrow", "function-key"))
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	resp, err := client.Post(origin.URL, "text/plain", strings.NewReader("azure"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello azure", string(body))
+
+	var reported *Response
+	transport.WithCallback(func(ctx context.Context, r *Response) { reported = r })
+	resp, err = client.Get(origin.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NotNil(t, reported)
+	assert.Equal(t, "azure.functions.eastus", reported.ProxyName)
+	assert.Equal(t, "203.0.113.9", reported.ClientDetails.SourceIP)
+	assert.Equal(t, "Go-http-client/1.1", reported.ClientDetails.UserAgent)
+
+	_, err = client.Get("ftp://example.com")
+	assert.ErrorContains(t, err, `proxy error [0] failed to execute http request: Get "ftp://example.com": unsupported protocol scheme`)
+
+	unauthorized := NewTransportWithBackend(NewAzureFunctionBackend(host.URL+"/api/burrow", ""))
+	_, err = (&http.Client{Transport: unauthorized}).Get(origin.URL)
+	assert.ErrorContains(t, err, "proxy returned non-200 status code: 401")
+}
+
+func TestAzureHandler_JSONBody(t *testing.T) {
+	// The host may pass JSON request bodies through as JSON values
+	handler := NewAzureHandler(Handler(func(ctx context.Context, req *Request) (*Response, error) {
+		return &Response{StatusCode: 200, Headers: map[string]string{"X-Url": req.URL}}, nil
+	}))
+	invocation := `{"Data":{"req":{"Method":"POST","Headers":{},"Body":{"url":"https://example.com"}}}}`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/burrow", strings.NewReader(invocation)))
+
+	var result azureInvocationResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 200, result.Outputs.Res.StatusCode)
+	var resp Response
+	require.NoError(t, json.Unmarshal([]byte(result.Outputs.Res.Body), &resp))
+	assert.Equal(t, "https://example.com", resp.Headers["X-Url"])
+}
+
+// handlerBackend invokes a Handler in process.
+type handlerBackend struct {
+	handler Handler
+}
+
+func (b handlerBackend) URL() string {
+	return "local://burrow"
+}
+
+func (b handlerBackend) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	return proxyReply(servePayload(ctx, b.handler, "local", payload, nil))
+}
+
+func TestTransport_CustomBackend(t *testing.T) {
+	backend := handlerBackend{Handler(func(ctx context.Context, req *Request) (*Response, error) {
+		if strings.HasSuffix(req.URL, "/timeout") {
+			return nil, ProxyErrorf(ProxyErrTimeout, "http request timed out")
+		}
+		return &Response{StatusCode: 202}, nil
+	})}
+	client := &http.Client{Transport: NewTransportWithBackend(backend)}
+
+	resp, err := client.Get("https://example.com")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 202, resp.StatusCode)
+	assert.Equal(t, "local://burrow", ResponseMetadata(resp).ProxyURL)
+
+	_, err = client.Get("https://example.com/timeout")
+	assert.EqualError(t, err, `Get "https://example.com/timeout": proxy error [4] http request timed out`)
+}
@@ -0,0 +1,376 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var _ http.RoundTripper = &BatchTransport{}
+
+// MaxBatchSize is the maximum number of requests accepted in one batch.
+const MaxBatchSize = 100
+
+// MaxBatchResponseBytes is the maximum size of the encoded results of one
+// batch. It keeps batch responses under Lambda's 6MB response limit, even
+// when they are encrypted.
+const MaxBatchResponseBytes = 4 * 1024 * 1024
+
+var (
+	defaultBatchConcurrency = 10
+	maxBatchConcurrency     = 50
+	defaultBatchWindow      = 10 * time.Millisecond
+)
+
+// BatchRequest contains several requests to be proxied in one invocation.
+type BatchRequest struct {
+	Requests    []*Request `json:"requests"`
+	Concurrency int        `json:"concurrency,omitempty"`
+}
+
+// BatchResponse contains the outcome of each request in a BatchRequest, in
+// the same order as the requests.
+type BatchResponse struct {
+	Results  []*BatchResult `json:"results"`
+	Duration float64        `json:"duration,omitempty"`
+}
+
+// BatchResult is the outcome of one request in a batch. Exactly one of
+// Response and Error is set.
+type BatchResult struct {
+	Response *Response   `json:"response,omitempty"`
+	Error    *ProxyError `json:"error,omitempty"`
+}
+
+// IsBatchPayload returns true if the JSON payload is a BatchRequest rather
+// than a single Request.
+func IsBatchPayload(payload []byte) bool {
+	var probe struct {
+		Requests json.RawMessage `json:"requests"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return false
+	}
+	return len(probe.Requests) > 0
+}
+
+// HandleBatch proxies each request in the batch using the handler, with at
+// most batch.Concurrency requests in flight at once. Failures of individual
+// requests are reported in their BatchResult rather than as an error. Results
+// that don't fit in MaxBatchResponseBytes, in the order of the requests, fail
+// with ProxyErrExceededMaxBodySize.
+func (h Handler) HandleBatch(ctx context.Context, batch *BatchRequest) (*BatchResponse, error) {
+	if len(batch.Requests) == 0 {
+		return nil, ProxyErrorf(ProxyErrBadRequest, "batch contains no requests")
+	}
+	if len(batch.Requests) > MaxBatchSize {
+		return nil, ProxyErrorf(ProxyErrBadRequest, "batch exceeds maximum size: %d", MaxBatchSize)
+	}
+	concurrency := batch.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	if concurrency > maxBatchConcurrency {
+		concurrency = maxBatchConcurrency
+	}
+	start := time.Now()
+	results := make([]*BatchResult, len(batch.Requests))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, req := range batch.Requests {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, req *Request) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = h.handleBatchItem(ctx, req)
+		}(i, req)
+	}
+	wg.Wait()
+	var size int
+	for i, result := range results {
+		encoded, err := json.Marshal(result)
+		if err != nil || size+len(encoded) > MaxBatchResponseBytes {
+			result = &BatchResult{Error: ProxyErrorf(ProxyErrExceededMaxBodySize,
+				"batch response exceeded maximum size: %d", MaxBatchResponseBytes)}
+			results[i] = result
+			encoded, _ = json.Marshal(result)
+		}
+		size += len(encoded)
+	}
+	return &BatchResponse{
+		Results:  results,
+		Duration: time.Since(start).Seconds(),
+	}, nil
+}
+
+func (h Handler) handleBatchItem(ctx context.Context, req *Request) *BatchResult {
+	if req == nil {
+		return &BatchResult{Error: ProxyErrorf(ProxyErrBadRequest, "request is required")}
+	}
+	resp, err := h(ctx, req)
+	if err != nil {
+		var proxyErr *ProxyError
+		if !errors.As(err, &proxyErr) {
+			proxyErr = ProxyErrorf(ProxyErrUnknown, "%v", err)
+		}
+		return &BatchResult{Error: proxyErr}
+	}
+	return &BatchResult{Response: resp}
+}
+
+// BatchTransport is an http.RoundTripper that coalesces concurrent requests
+// into batches, so that many requests are proxied in a single invocation.
+// Requests are collected for a short window, or until the maximum batch size
+// is reached, and then sent together to the proxy of the underlying
+// Transport. Each caller receives its own response.
+//
+// Each request is reported to the hooks, tracer and metrics of the Transport
+// as if it had been sent alone, with the bytes of its batch divided evenly
+// among the requests. The usage accountant counts each batch as one
+// invocation, and its budget is checked before each batch is sent.
+//
+// The response size limit of each request is lowered so that the responses
+// of a batch fit in MaxBatchResponseBytes together.
+type BatchTransport struct {
+	transport    *Transport
+	window       time.Duration
+	maxBatchSize int
+	concurrency  int
+	mutex        sync.Mutex
+	pending      []*batchCall
+	timer        *time.Timer
+}
+
+// batchCall is a request waiting to be sent in a batch.
+type batchCall struct {
+	ctx  context.Context
+	req  *http.Request
+	ser  *Request
+	done chan batchOutcome
+}
+
+type batchOutcome struct {
+	resp    *http.Response
+	serResp *Response
+	stats   roundTripStats
+	err     error
+}
+
+// NewBatchTransport creates a BatchTransport that sends batches to the proxy
+// of the provided Transport, using its client and request settings.
+func NewBatchTransport(transport *Transport) *BatchTransport {
+	return &BatchTransport{
+		transport:    transport,
+		window:       defaultBatchWindow,
+		maxBatchSize: MaxBatchSize,
+		concurrency:  defaultBatchConcurrency,
+	}
+}
+
+// WithWindow sets how long requests are collected before a batch is sent.
+func (b *BatchTransport) WithWindow(window time.Duration) *BatchTransport {
+	b.window = window
+	return b
+}
+
+// WithMaxBatchSize sets the maximum number of requests in one batch. A batch
+// is sent immediately once it reaches this size.
+func (b *BatchTransport) WithMaxBatchSize(size int) *BatchTransport {
+	if size <= 0 || size > MaxBatchSize {
+		size = MaxBatchSize
+	}
+	b.maxBatchSize = size
+	return b
+}
+
+// WithConcurrency sets the number of requests the proxy executes concurrently
+// within a batch.
+func (b *BatchTransport) WithConcurrency(concurrency int) *BatchTransport {
+	b.concurrency = concurrency
+	return b
+}
+
+// ProxyURL returns the URL of the proxy used by the transport
+func (b *BatchTransport) ProxyURL() string {
+	return b.transport.ProxyURL()
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (b *BatchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := b.transport
+	if t.accountant != nil {
+		if err := t.accountant.Check(); err != nil {
+			return nil, err
+		}
+	}
+	attempt := t.beginAttempt(req)
+	ser, err := t.serializeRequest(attempt.ctx, req)
+	if err != nil {
+		attempt.end(nil, err, &roundTripStats{}, false)
+		return nil, err
+	}
+	call := &batchCall{ctx: req.Context(), req: req, ser: ser, done: make(chan batchOutcome, 1)}
+	b.enqueue(call)
+	select {
+	case outcome := <-call.done:
+		attempt.end(outcome.serResp, outcome.err, &outcome.stats, false)
+		return outcome.resp, outcome.err
+	case <-call.ctx.Done():
+		err := call.ctx.Err()
+		attempt.end(nil, err, &roundTripStats{}, false)
+		return nil, err
+	}
+}
+
+func (b *BatchTransport) enqueue(call *batchCall) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.pending = append(b.pending, call)
+	if len(b.pending) >= b.maxBatchSize {
+		b.flushLocked()
+		return
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+}
+
+func (b *BatchTransport) flush() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.flushLocked()
+}
+
+// flushLocked sends the pending calls as a batch. The mutex must be held.
+func (b *BatchTransport) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	calls := b.pending
+	b.pending = nil
+	go b.send(calls)
+}
+
+// send proxies a batch and delivers the outcome to each call. The batch is
+// canceled only once every caller has given up.
+func (b *BatchTransport) send(calls []*batchCall) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		for _, call := range calls {
+			select {
+			case <-call.ctx.Done():
+			case <-finished:
+				return
+			}
+		}
+		cancel()
+	}()
+
+	// Share the response size limit of the batch among its requests, whose
+	// bodies are base64 encoded in the response
+	maxResponseBytes := int64(MaxBatchResponseBytes/len(calls)) * 3 / 4
+	batch := &BatchRequest{Concurrency: b.concurrency}
+	for _, call := range calls {
+		ser := *call.ser
+		if ser.MaxResponseBytes <= 0 || ser.MaxResponseBytes > maxResponseBytes {
+			ser.MaxResponseBytes = maxResponseBytes
+		}
+		batch.Requests = append(batch.Requests, &ser)
+	}
+	start := time.Now()
+	var stats roundTripStats
+	batchResp, err := b.invoke(ctx, batch, &stats)
+	if err == nil && len(batchResp.Results) != len(calls) {
+		err = &protocolError{fmt.Errorf("proxy returned %d results for %d requests",
+			len(batchResp.Results), len(calls))}
+	}
+	roundTrip := time.Since(start)
+	// Divide the bytes of the batch among its requests
+	n := int64(len(calls))
+	callStats := roundTripStats{sent: stats.sent / n, received: stats.received / n}
+	for i, call := range calls {
+		if err != nil {
+			call.done <- batchOutcome{stats: callStats, err: err}
+			continue
+		}
+		outcome := b.outcome(call, batchResp.Results[i], roundTrip)
+		outcome.stats = callStats
+		call.done <- outcome
+	}
+}
+
+// invoke sends the batch to the proxy in one invocation, which is reported
+// to the usage accountant.
+func (b *BatchTransport) invoke(ctx context.Context, batch *BatchRequest, stats *roundTripStats) (*BatchResponse, error) {
+	accountant := b.transport.accountant
+	if accountant == nil {
+		return b.post(ctx, batch, stats)
+	}
+	reserved, err := accountant.reserve()
+	if err != nil {
+		return nil, err
+	}
+	defer accountant.release(reserved)
+	start := time.Now()
+	batchResp, err := b.post(ctx, batch, stats)
+	// The batch is billed as one invocation of its total duration
+	var serResp *Response
+	if batchResp != nil {
+		serResp = &Response{Duration: batchResp.Duration}
+		for _, result := range batchResp.Results {
+			if result != nil && result.Response != nil {
+				serResp.ProxyName = result.Response.ProxyName
+				break
+			}
+		}
+	}
+	accountant.ObserveRequest(newRequestObservation(b.transport.ProxyURL(), serResp, err, time.Since(start), stats))
+	return batchResp, err
+}
+
+func (b *BatchTransport) post(ctx context.Context, batch *BatchRequest, stats *roundTripStats) (*BatchResponse, error) {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal batch: %w", err)
+	}
+	stats.sent = int64(len(payload))
+	body, err := b.transport.backend.Invoke(ctx, payload)
+	stats.received = int64(len(body))
+	if err != nil {
+		return nil, err
+	}
+	var batchResp BatchResponse
+	if err := json.Unmarshal(body, &batchResp); err != nil {
+		return nil, &protocolError{fmt.Errorf("failed to unmarshal batch response: %w", err)}
+	}
+	return &batchResp, nil
+}
+
+func (b *BatchTransport) outcome(call *batchCall, result *BatchResult, roundTrip time.Duration) batchOutcome {
+	if result == nil {
+		return batchOutcome{err: &protocolError{errors.New("proxy returned an empty batch result")}}
+	}
+	if result.Error != nil {
+		return batchOutcome{err: result.Error}
+	}
+	if result.Response == nil {
+		return batchOutcome{err: &protocolError{errors.New("batch result is missing a response")}}
+	}
+	resp, err := b.transport.deserializeResponse(call.req, result.Response, roundTrip)
+	if err != nil {
+		return batchOutcome{err: err}
+	}
+	return batchOutcome{resp: resp, serResp: result.Response}
+}
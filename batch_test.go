@@ -0,0 +1,276 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_HandleBatch(t *testing.T) {
+	var inFlight, maxInFlight int32
+	handler := Handler(func(ctx context.Context, req *Request) (*Response, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if n <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if req.URL == "bad" {
+			return nil, ProxyErrorf(ProxyErrBadRequest, "bad url")
+		}
+		return &Response{StatusCode: 200, Body: req.URL}, nil
+	})
+
+	batch := &BatchRequest{Concurrency: 2}
+	for i := 0; i < 6; i++ {
+		batch.Requests = append(batch.Requests, &Request{URL: fmt.Sprintf("url-%d", i)})
+	}
+	batch.Requests = append(batch.Requests, &Request{URL: "bad"})
+
+	resp, err := handler.HandleBatch(context.Background(), batch)
+	require.NoError(t, err)
+	require.Len(t, resp.Results, 7)
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, fmt.Sprintf("url-%d", i), resp.Results[i].Response.Body)
+	}
+	assert.Nil(t, resp.Results[6].Response)
+	assert.Equal(t, ProxyErrBadRequest, resp.Results[6].Error.Type)
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(2))
+
+	_, err = handler.HandleBatch(context.Background(), &BatchRequest{})
+	assert.EqualError(t, err, "proxy error [1] batch contains no requests")
+}
+
+func TestHandler_HandleBatchResponseLimit(t *testing.T) {
+	handler := Handler(func(ctx context.Context, req *Request) (*Response, error) {
+		size := 10
+		if req.URL == "large" {
+			size = MaxBatchResponseBytes * 2 / 5
+		}
+		return &Response{StatusCode: 200, Body: strings.Repeat("a", size)}, nil
+	})
+	batch := &BatchRequest{}
+	for _, url := range []string{"small", "large", "large", "large", "small"} {
+		batch.Requests = append(batch.Requests, &Request{URL: url})
+	}
+	resp, err := handler.HandleBatch(context.Background(), batch)
+	require.NoError(t, err)
+
+	// Only the result that overflows the response fails
+	require.Len(t, resp.Results, 5)
+	for _, i := range []int{0, 1, 2, 4} {
+		assert.NotNil(t, resp.Results[i].Response, i)
+	}
+	require.NotNil(t, resp.Results[3].Error)
+	assert.Equal(t, ProxyErrExceededMaxBodySize, resp.Results[3].Error.Type)
+	encoded, err := json.Marshal(resp.Results)
+	require.NoError(t, err)
+	assert.Less(t, len(encoded), MaxBatchResponseBytes)
+}
+
+func TestIsBatchPayload(t *testing.T) {
+	assert.True(t, IsBatchPayload([]byte(`{"requests":[{"url":"https://example.com"}]}`)))
+	assert.False(t, IsBatchPayload([]byte(`{"url":"https://example.com"}`)))
+	assert.False(t, IsBatchPayload([]byte(`not json`)))
+}
+
+func TestBatchTransport(t *testing.T) {
+	handler := Handler(func(ctx context.Context, req *Request) (*Response, error) {
+		if req.URL == "https://example.com/fail" {
+			return nil, ProxyErrorf(ProxyErrTimeout, "http request timed out")
+		}
+		return &Response{StatusCode: 200, Body: "b2s=", ProxyName: "batch"}, nil
+	})
+	var invocations, batchSizes int32
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&invocations, 1)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.True(t, IsBatchPayload(body))
+		var batch BatchRequest
+		require.NoError(t, json.Unmarshal(body, &batch))
+		atomic.AddInt32(&batchSizes, int32(len(batch.Requests)))
+		resp, err := handler.HandleBatch(r.Context(), &batch)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer mockProxy.Close()
+
+	transport := NewBatchTransport(NewTransport(mockProxy.URL, "POST")).
+		WithWindow(50 * time.Millisecond)
+	client := &http.Client{Transport: transport}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	bodies := make([]string, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			url := fmt.Sprintf("https://example.com/%d", i)
+			if i == 4 {
+				url = "https://example.com/fail"
+			}
+			resp, err := client.Get(url)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(body)
+			assert.Equal(t, "batch", ResponseMetadata(resp).ProxyName)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&invocations))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&batchSizes))
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, "ok", bodies[i])
+	}
+	assert.EqualError(t, errs[4], `Get "https://example.com/fail": proxy error [4] http request timed out`)
+}
+
+func TestBatchTransport_MaxBatchSize(t *testing.T) {
+	var invocations int32
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&invocations, 1)
+		var batch BatchRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		resp := &BatchResponse{}
+		for range batch.Requests {
+			resp.Results = append(resp.Results, &BatchResult{Response: &Response{StatusCode: 204}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer mockProxy.Close()
+
+	transport := NewBatchTransport(NewTransport(mockProxy.URL, "POST")).
+		WithWindow(time.Hour).
+		WithMaxBatchSize(2)
+	client := &http.Client{Transport: transport}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get("https://example.com")
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+				assert.Equal(t, 204, resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&invocations))
+}
+
+func TestBatchTransport_MaxResponseBytes(t *testing.T) {
+	limits := make(chan int64, 3)
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch BatchRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		resp := &BatchResponse{}
+		for _, req := range batch.Requests {
+			limits <- req.MaxResponseBytes
+			resp.Results = append(resp.Results, &BatchResult{Response: &Response{StatusCode: 204}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer mockProxy.Close()
+
+	// The limit of each request is lowered to its share of the batch
+	transport := NewBatchTransport(NewTransport(mockProxy.URL, "POST")).
+		WithWindow(time.Hour).
+		WithMaxBatchSize(2)
+	client := &http.Client{Transport: transport}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get("https://example.com")
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(MaxBatchResponseBytes/2*3/4), <-limits)
+	assert.Equal(t, int64(MaxBatchResponseBytes/2*3/4), <-limits)
+
+	// Lower limits are kept
+	transport = NewBatchTransport(NewTransport(mockProxy.URL, "POST").WithMaxResponseBytes(1000)).
+		WithWindow(time.Millisecond)
+	resp, err := (&http.Client{Transport: transport}).Get("https://example.com")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int64(1000), <-limits)
+}
+
+func TestBatchTransport_HooksAndBudget(t *testing.T) {
+	var invocations int32
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&invocations, 1)
+		var batch BatchRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		resp := &BatchResponse{Duration: 0.5}
+		for range batch.Requests {
+			resp.Results = append(resp.Results, &BatchResult{Response: &Response{StatusCode: 200, ProxyName: "batch"}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer mockProxy.Close()
+
+	recorder := &hookRecorder{events: map[string][]HookEvent{}}
+	accountant := NewUsageAccountant().
+		WithPriceTable(PriceTable{PerRequest: 1}).
+		WithBudget(1)
+	client := NewClient(
+		WithProxyURL(mockProxy.URL),
+		WithBatching(50*time.Millisecond),
+		WithHooks(recorder.hooks()),
+		WithUsageAccountant(accountant),
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get("https://example.com")
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&invocations))
+	assert.Len(t, recorder.events["request"], 3)
+	assert.Len(t, recorder.events["response"], 3)
+
+	// The batch is billed as one invocation, which reaches the budget
+	total := accountant.Total()
+	assert.Equal(t, int64(1), total.Invocations)
+	assert.Equal(t, 500*time.Millisecond, total.BilledDuration)
+	_, err := client.Get("https://example.com")
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&invocations))
+}
@@ -3,6 +3,8 @@ package burrow
 import (
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // NewRoundRobinClient is a convenience function for creating an http.Client
@@ -25,12 +27,31 @@ type ClientOption func(*clientConfig)
 
 type clientConfig struct {
 	proxyURLs           []string
+	backends            []Backend
 	retries             int
 	retryableCodes      []int
 	callback            ProxyCallback
 	timeout             time.Duration
 	maxResponseBytes    int64
 	allowedContentTypes []string
+	stripHeaders        []string
+	profiles            *ProfileCatalog
+	profileRotation     ProfileRotation
+	orderedHeaders      bool
+	tlsProfile          string
+	diagnostics         bool
+	metadataHeaders     bool
+	hooks               *Hooks
+	circuitThreshold    int
+	circuitCooldown     time.Duration
+	tracerProvider      trace.TracerProvider
+	metrics             Metrics
+	accountant          *UsageAccountant
+	egressTracker       *EgressTracker
+	retireEvery         int
+	credentials         CredentialsProvider
+	keyring             *Keyring
+	batchWindow         time.Duration
 }
 
 // WithProxyURL sets a single proxy URL for the client
@@ -47,6 +68,15 @@ func WithProxyURLs(urls []string) ClientOption {
 	}
 }
 
+// WithBackends sets backends for proxies that are not invoked through a
+// Function URL, e.g. a LambdaBackend or a NewCloudFunctionBackend. They are
+// used alongside any proxy URLs.
+func WithBackends(backends ...Backend) ClientOption {
+	return func(c *clientConfig) {
+		c.backends = backends
+	}
+}
+
 // WithRetries sets the number of retries for the client
 func WithRetries(retries int) ClientOption {
 	return func(c *clientConfig) {
@@ -90,6 +120,143 @@ func WithAllowedContentTypes(allowedContentTypes []string) ClientOption {
 	}
 }
 
+// WithStripHeaders sets request headers that are never sent to the proxy
+func WithStripHeaders(headers ...string) ClientOption {
+	return func(c *clientConfig) {
+		c.stripHeaders = headers
+	}
+}
+
+// WithHeaderProfiles sends requests with the headers of browser profiles
+// from the catalog, e.g. DefaultProfileCatalog(). With StickyPerProxy each
+// proxy keeps using the same profile.
+func WithHeaderProfiles(catalog *ProfileCatalog, rotation ProfileRotation) ClientOption {
+	return func(c *clientConfig) {
+		c.profiles = catalog
+		c.profileRotation = rotation
+	}
+}
+
+// WithOrderedHeaders asks proxies to send headers in order with their casing
+// intact, as a browser would. See Transport.WithOrderedHeaders.
+func WithOrderedHeaders(enabled bool) ClientOption {
+	return func(c *clientConfig) {
+		c.orderedHeaders = enabled
+	}
+}
+
+// WithTLSProfile asks proxies to connect upstream with the named TLS profile,
+// e.g. TLSProfileChrome
+func WithTLSProfile(name string) ClientOption {
+	return func(c *clientConfig) {
+		c.tlsProfile = name
+	}
+}
+
+// WithDiagnostics enables collection of upstream connection diagnostics
+func WithDiagnostics(enabled bool) ClientOption {
+	return func(c *clientConfig) {
+		c.diagnostics = enabled
+	}
+}
+
+// WithMetadataHeaders enables synthesized X-Burrow-* response headers that
+// describe the proxy that served each request
+func WithMetadataHeaders(enabled bool) ClientOption {
+	return func(c *clientConfig) {
+		c.metadataHeaders = enabled
+	}
+}
+
+// WithHooks sets lifecycle hooks that are invoked for each proxied request,
+// retry, proxy error and circuit breaker event
+func WithHooks(hooks *Hooks) ClientOption {
+	return func(c *clientConfig) {
+		c.hooks = hooks
+	}
+}
+
+// WithCircuitBreaker takes a proxy out of rotation for the cooldown period
+// after threshold consecutive proxy failures
+func WithCircuitBreaker(threshold int, cooldown time.Duration) ClientOption {
+	return func(c *clientConfig) {
+		c.circuitThreshold = threshold
+		c.circuitCooldown = cooldown
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used to trace
+// proxied requests. Defaults to the global tracer provider.
+func WithTracerProvider(tp trace.TracerProvider) ClientOption {
+	return func(c *clientConfig) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithMetrics sets the collector that records measurements of proxied
+// requests and retries, e.g. one from the github.com/myzie/burrow/prometheus
+// module
+func WithMetrics(metrics Metrics) ClientOption {
+	return func(c *clientConfig) {
+		c.metrics = metrics
+	}
+}
+
+// WithUsageAccountant sets the accountant that records the estimated cost of
+// proxied requests and optionally enforces a budget
+func WithUsageAccountant(accountant *UsageAccountant) ClientOption {
+	return func(c *clientConfig) {
+		c.accountant = accountant
+	}
+}
+
+// WithRetireEvery asks each proxy to retire its execution environment after
+// every n requests, to obtain a fresh egress IP. Use RetireEnvironment to
+// retire after a specific request instead.
+func WithRetireEvery(n int) ClientOption {
+	return func(c *clientConfig) {
+		c.retireEvery = n
+	}
+}
+
+// WithSigV4 signs proxy requests with SigV4 using the provided credentials,
+// or DefaultCredentials if nil, for Function URLs that use AWS_IAM auth. The
+// region of each proxy is taken from its Function URL.
+func WithSigV4(credentials CredentialsProvider) ClientOption {
+	return func(c *clientConfig) {
+		if credentials == nil {
+			credentials = DefaultCredentials()
+		}
+		c.credentials = credentials
+	}
+}
+
+// WithEncryption encrypts requests to the proxies and their replies with keys
+// from the keyring, so that URLs, headers and bodies aren't visible to the
+// platform. The proxies must be configured with the same keys.
+func WithEncryption(keyring *Keyring) ClientOption {
+	return func(c *clientConfig) {
+		c.keyring = keyring
+	}
+}
+
+// WithBatching coalesces concurrent requests to each proxy into batches
+// collected for the given window, so that they share one invocation. See
+// BatchTransport.
+func WithBatching(window time.Duration) ClientOption {
+	return func(c *clientConfig) {
+		c.batchWindow = window
+	}
+}
+
+// WithEgressTracker sets the tracker that records the egress IPs reported by
+// the proxies
+func WithEgressTracker(tracker *EgressTracker) ClientOption {
+	return func(c *clientConfig) {
+		c.egressTracker = tracker
+	}
+}
+
 // NewClient creates an http.Client with the provided Burrow options.
 // If no proxy URLs are provided, a vanilla http.Client is returned.
 func NewClient(opts ...ClientOption) *http.Client {
@@ -97,19 +264,24 @@ func NewClient(opts ...ClientOption) *http.Client {
 }
 
 // NewTransportWithOptions creates a new http.RoundTripper with the provided
-// Burrow options. If no proxy URLs are provided, the default HTTP transport is
-// returned.
+// Burrow options. If no proxy URLs or backends are provided, the default HTTP
+// transport is returned.
 func NewTransportWithOptions(opts ...ClientOption) http.RoundTripper {
 	cfg := &clientConfig{}
 	for _, opt := range opts {
 		opt(cfg)
 	}
-	if len(cfg.proxyURLs) == 0 {
+	if len(cfg.proxyURLs) == 0 && len(cfg.backends) == 0 {
 		return http.DefaultTransport
 	}
-	var transports []http.RoundTripper
+	var backends []Backend
 	for _, proxyURL := range cfg.proxyURLs {
-		transport := NewTransport(proxyURL, "POST")
+		backends = append(backends, NewHTTPBackend(proxyURL))
+	}
+	backends = append(backends, cfg.backends...)
+	var transports []http.RoundTripper
+	for _, backend := range backends {
+		transport := NewTransportWithBackend(backend)
 		if cfg.callback != nil {
 			transport.WithCallback(cfg.callback)
 		}
@@ -122,6 +294,52 @@ func NewTransportWithOptions(opts ...ClientOption) http.RoundTripper {
 		if len(cfg.allowedContentTypes) > 0 {
 			transport.WithAllowedContentTypes(cfg.allowedContentTypes)
 		}
+		if len(cfg.stripHeaders) > 0 {
+			transport.WithStripHeaders(cfg.stripHeaders...)
+		}
+		if cfg.profiles != nil {
+			transport.WithHeaderProfiles(cfg.profiles, cfg.profileRotation)
+		}
+		if cfg.orderedHeaders {
+			transport.WithOrderedHeaders(true)
+		}
+		if cfg.tlsProfile != "" {
+			transport.WithTLSProfile(cfg.tlsProfile)
+		}
+		if cfg.diagnostics {
+			transport.WithDiagnostics(true)
+		}
+		if cfg.metadataHeaders {
+			transport.WithMetadataHeaders(true)
+		}
+		if cfg.hooks != nil {
+			transport.WithHooks(cfg.hooks)
+		}
+		if cfg.tracerProvider != nil {
+			transport.WithTracerProvider(cfg.tracerProvider)
+		}
+		if cfg.metrics != nil {
+			transport.WithMetrics(cfg.metrics)
+		}
+		if cfg.accountant != nil {
+			transport.WithUsageAccountant(cfg.accountant)
+		}
+		if cfg.egressTracker != nil {
+			transport.WithEgressTracker(cfg.egressTracker)
+		}
+		if cfg.retireEvery > 0 {
+			transport.WithRetireEvery(cfg.retireEvery)
+		}
+		if cfg.credentials != nil {
+			transport.WithSigV4("", cfg.credentials)
+		}
+		if cfg.keyring != nil {
+			transport.WithEncryption(cfg.keyring)
+		}
+		if cfg.batchWindow > 0 {
+			transports = append(transports, NewBatchTransport(transport).WithWindow(cfg.batchWindow))
+			continue
+		}
 		transports = append(transports, transport)
 	}
 	rr := NewRoundRobinTransport(transports)
@@ -131,5 +349,14 @@ func NewTransportWithOptions(opts ...ClientOption) http.RoundTripper {
 	if cfg.retryableCodes != nil {
 		rr.WithRetryableCodes(cfg.retryableCodes)
 	}
+	if cfg.hooks != nil {
+		rr.WithHooks(cfg.hooks)
+	}
+	if cfg.metrics != nil {
+		rr.WithMetrics(cfg.metrics)
+	}
+	if cfg.circuitThreshold > 0 {
+		rr.WithCircuitBreaker(cfg.circuitThreshold, cfg.circuitCooldown)
+	}
 	return rr
 }
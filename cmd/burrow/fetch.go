@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/myzie/burrow"
+)
+
+func init() {
+	commands["fetch"] = runFetch
+}
+
+const fetchUsage = `Usage:
+  burrow fetch [flags] JOBS
+
+Fetches each job in a JSONL file (or - for stdin) through the proxies. Each
+line is a Request, e.g. {"url": "https://example.com", "method": "GET"}, with
+an optional "id" that is copied to the result. A job's "timeout" overrides
+-timeout, and its "max_response_bytes" and "allowed_content_types" are passed
+to the proxy. One JSON result is written per job, in completion order. With
+-o, the output file doubles as a checkpoint: rerunning the same command skips
+jobs that already have a result.
+`
+
+// maxJobSize is the maximum size of one line in the jobs file.
+const maxJobSize = 64 * 1024 * 1024
+
+// fetchJob is one line of the jobs file.
+type fetchJob struct {
+	ID string `json:"id,omitempty"`
+	burrow.Request
+}
+
+// fetchResult is one line of the fetch output. Job is the 1-based line
+// number of the job in the jobs file.
+type fetchResult struct {
+	Job      int    `json:"job"`
+	ID       string `json:"id,omitempty"`
+	URL      string `json:"url,omitempty"`
+	BodyFile string `json:"body_file,omitempty"`
+	*requestOutput
+}
+
+// fetchStats counts the outcomes of a fetch.
+type fetchStats struct {
+	completed, failed, skipped atomic.Int64
+}
+
+func runFetch(args []string) error {
+	fs := flag.NewFlagSet("burrow fetch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), fetchUsage, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	var proxies proxyFlags
+	var output, bodies string
+	var concurrency, retries int
+	var timeout time.Duration
+	var diagnostics, retryFailed bool
+	proxies.register(fs)
+	fs.StringVar(&output, "o", "", "Output file, also used as the checkpoint (default stdout)")
+	fs.StringVar(&bodies, "bodies", "", "Write response bodies to files in this directory instead of inline")
+	fs.IntVar(&concurrency, "c", 10, "Number of jobs to run concurrently")
+	fs.IntVar(&retries, "retries", 2, "Number of retries on retryable status codes")
+	fs.DurationVar(&timeout, "timeout", 0, "Upstream request timeout passed to the proxy")
+	fs.BoolVar(&diagnostics, "diagnostics", false, "Collect upstream connection diagnostics")
+	fs.BoolVar(&retryFailed, "retry-failed", false, "When resuming, rerun jobs whose previous result was an error")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one jobs file is required")
+	}
+	if concurrency <= 0 {
+		return errors.New("concurrency must be positive")
+	}
+	proxyURLs, err := proxies.urls()
+	if err != nil {
+		return err
+	}
+	if bodies != "" {
+		if err := os.MkdirAll(bodies, 0o755); err != nil {
+			return err
+		}
+	}
+
+	jobs, err := openInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer jobs.Close()
+
+	out := io.Writer(os.Stdout)
+	var done map[int]bool
+	if output != "" {
+		file, checkpoint, err := openCheckpoint(output, retryFailed)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		done, out = checkpoint, file
+	}
+
+	opts := []burrow.ClientOption{
+		burrow.WithProxyURLs(proxyURLs),
+		burrow.WithRetries(retries),
+		burrow.WithDiagnostics(diagnostics),
+	}
+	if timeout > 0 {
+		opts = append(opts, burrow.WithTimeout(timeout))
+	}
+	f := &fetcher{
+		client:  burrow.NewClient(opts...),
+		bodies:  bodies,
+		encoder: json.NewEncoder(out),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var stats fetchStats
+	queue := make(chan pendingJob)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				f.run(ctx, job, &stats)
+			}
+		}()
+	}
+	readErr := readJobs(ctx, jobs, done, queue, &stats)
+	close(queue)
+	wg.Wait()
+
+	fmt.Fprintf(os.Stderr, "burrow: %d completed, %d failed, %d skipped\n",
+		stats.completed.Load(), stats.failed.Load(), stats.skipped.Load())
+	if readErr != nil {
+		return readErr
+	}
+	if ctx.Err() != nil {
+		return errors.New("interrupted; rerun the same command to resume")
+	}
+	return nil
+}
+
+// pendingJob is a raw job waiting to be run.
+type pendingJob struct {
+	line int
+	raw  []byte
+}
+
+// readJobs sends each job that has not already been completed to the queue,
+// until the jobs are exhausted or the context is canceled. Jobs are parsed by
+// the workers so that invalid jobs are reported as results.
+func readJobs(ctx context.Context, r io.Reader, done map[int]bool, queue chan<- pendingJob, stats *fetchStats) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxJobSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		if done[line] {
+			stats.skipped.Add(1)
+			continue
+		}
+		select {
+		case queue <- pendingJob{line: line, raw: bytes.Clone(raw)}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// openCheckpoint opens the output file for appending and returns the jobs
+// that already have a result in it. A partial final line, e.g. after a crash,
+// is truncated so that new results start on a line of their own.
+func openCheckpoint(path string, retryFailed bool) (*os.File, map[int]bool, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	done, size, err := readCheckpoint(file, retryFailed)
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, done, nil
+}
+
+// readCheckpoint returns the jobs that already have a result in the output,
+// and the size of its complete lines. A partial final line is ignored so
+// that the job runs again. Failed jobs are excluded if retryFailed is set.
+func readCheckpoint(r io.Reader, retryFailed bool) (map[int]bool, int64, error) {
+	done := map[int]bool{}
+	reader := bufio.NewReader(r)
+	var size int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return done, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size += int64(len(line))
+		var result struct {
+			Job   int    `json:"job"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(line, &result); err != nil || result.Job <= 0 {
+			continue
+		}
+		done[result.Job] = !retryFailed || result.Error == ""
+	}
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// fetcher runs jobs and writes their results.
+type fetcher struct {
+	client  *http.Client
+	bodies  string
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func (f *fetcher) run(ctx context.Context, job pendingJob, stats *fetchStats) {
+	result := f.fetch(ctx, job)
+	// Jobs interrupted by cancellation are not recorded, so they run again
+	// when the fetch is resumed
+	if ctx.Err() != nil {
+		return
+	}
+	if result.Error != "" {
+		stats.failed.Add(1)
+	} else {
+		stats.completed.Add(1)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.encoder.Encode(result); err != nil {
+		fmt.Fprintln(os.Stderr, "burrow: failed to write result:", err)
+	}
+}
+
+func (f *fetcher) fetch(ctx context.Context, job pendingJob) *fetchResult {
+	result := &fetchResult{Job: job.line, requestOutput: &requestOutput{}}
+	var j fetchJob
+	if err := json.Unmarshal(job.raw, &j); err != nil {
+		result.Error = fmt.Sprintf("invalid job: %v", err)
+		return result
+	}
+	result.ID = j.ID
+	result.URL = j.URL
+	req, err := newJobRequest(ctx, &j)
+	if err != nil {
+		result.Error = fmt.Sprintf("invalid job: %v", err)
+		return result
+	}
+
+	start := time.Now()
+	resp, err := f.client.Do(req)
+	if err != nil {
+		result.requestOutput = errorOutput(err, time.Since(start))
+		return result
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.requestOutput = errorOutput(err, time.Since(start))
+		return result
+	}
+	result.requestOutput = newRequestOutput(resp, body, time.Since(start))
+	if f.bodies != "" {
+		result.BodyFile = filepath.Join(f.bodies, fmt.Sprintf("%08d.body", job.line))
+		result.Body, result.BodyBase64 = "", ""
+		if err := os.WriteFile(result.BodyFile, body, 0o644); err != nil {
+			result.Error = fmt.Sprintf("failed to write body: %v", err)
+		}
+	}
+	return result
+}
+
+// newJobRequest creates the http request described by a job.
+func newJobRequest(ctx context.Context, job *fetchJob) (*http.Request, error) {
+	if job.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	method := job.Method
+	if method == "" {
+		method = "GET"
+	}
+	var body io.Reader
+	if job.Body != "" {
+		decoded, err := base64.StdEncoding.DecodeString(job.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode body: %w", err)
+		}
+		body = bytes.NewReader(decoded)
+	}
+	// The proxy enforces the limits of the job
+	ctx = burrow.UseRequestLimits(ctx, &burrow.RequestLimits{
+		Timeout:             time.Duration(job.Timeout * float64(time.Second)),
+		MaxResponseBytes:    job.MaxResponseBytes,
+		AllowedContentTypes: job.AllowedContentTypes,
+	})
+	req, err := http.NewRequestWithContext(ctx, method, job.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range job.Headers {
+		req.Header.Set(k, v)
+	}
+	if job.Cookies != "" {
+		req.Header.Set("Cookie", job.Cookies)
+	}
+	return req, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/myzie/burrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCheckpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		retryFailed bool
+		want        map[int]bool
+		wantSize    int
+	}{
+		{"empty", "", false, map[int]bool{}, 0},
+		{
+			"complete",
+			`{"job":1}` + "\n" + `{"job":3,"error":"failed"}` + "\n",
+			false,
+			map[int]bool{1: true, 3: true},
+			37,
+		},
+		{
+			"retry failed",
+			`{"job":1}` + "\n" + `{"job":3,"error":"failed"}` + "\n",
+			true,
+			map[int]bool{1: true, 3: false},
+			37,
+		},
+		{
+			"partial last line",
+			`{"job":1}` + "\n" + `{"job":2,"status_co`,
+			false,
+			map[int]bool{1: true},
+			10,
+		},
+		{
+			"complete last line without newline",
+			`{"job":1}` + "\n" + `{"job":2}`,
+			false,
+			map[int]bool{1: true},
+			10,
+		},
+		{
+			"invalid lines",
+			"garbage\n" + `{"job":0}` + "\n" + `{"job":4}` + "\n",
+			false,
+			map[int]bool{4: true},
+			28,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done, size, err := readCheckpoint(strings.NewReader(tt.output), tt.retryFailed)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, done)
+			assert.Equal(t, int64(tt.wantSize), size)
+		})
+	}
+}
+
+func TestOpenCheckpoint_TruncatesPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"job":1}`+"\n"+`{"job":2,"bo`), 0o644))
+
+	file, done, err := openCheckpoint(path, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]bool{1: true}, done)
+	require.NoError(t, json.NewEncoder(file).Encode(map[string]int{"job": 2}))
+	require.NoError(t, file.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"job":1}`+"\n"+`{"job":2}`+"\n", string(data))
+}
+
+func TestFetcher_ForwardsJobLimits(t *testing.T) {
+	requests := make(chan burrow.Request, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req burrow.Request
+		json.NewDecoder(r.Body).Decode(&req)
+		requests <- req
+		json.NewEncoder(w).Encode(burrow.Response{StatusCode: 200})
+	}))
+	defer proxy.Close()
+
+	var out strings.Builder
+	f := &fetcher{
+		client:  burrow.NewClient(burrow.WithProxyURL(proxy.URL)),
+		encoder: json.NewEncoder(&out),
+	}
+	var stats fetchStats
+	f.run(context.Background(), pendingJob{line: 1, raw: []byte(`{
+		"id": "a",
+		"url": "https://example.com",
+		"timeout": 2.5,
+		"max_response_bytes": 100,
+		"allowed_content_types": ["text/html"]
+	}`)}, &stats)
+
+	req := <-requests
+	assert.Equal(t, 2.5, req.Timeout)
+	assert.Equal(t, int64(100), req.MaxResponseBytes)
+	assert.Equal(t, []string{"text/html"}, req.AllowedContentTypes)
+	assert.Equal(t, int64(1), stats.completed.Load())
+	assert.Contains(t, out.String(), `"id":"a"`)
+}
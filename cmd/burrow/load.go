@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/myzie/burrow"
+)
+
+func init() {
+	commands["load"] = runLoad
+}
+
+const loadUsage = `Usage:
+  burrow load [flags] URL
+
+Generates load against URL through the proxies for a fixed duration, and
+reports latency percentiles, status codes and errors per region.
+
+With -rps, requests are sent on a fixed schedule regardless of how long
+earlier requests take, and latency is measured from the time each request was
+scheduled to be sent. This avoids coordinated omission, where a slow target
+causes fewer requests to be sent and hides its own latency. With -c, a fixed
+number of workers send requests back to back and latency is the service time.
+`
+
+// loadTarget is a proxy that load is sent through.
+type loadTarget struct {
+	name      string
+	transport http.RoundTripper
+	weight    float64
+	stats     *loadStats
+}
+
+// loadStats collects the outcomes of requests sent through one proxy.
+type loadStats struct {
+	mutex     sync.Mutex
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    map[string]int
+}
+
+func (s *loadStats) record(latency time.Duration, status int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.latencies = append(s.latencies, latency)
+	if err != nil {
+		s.errors[errorName(err)]++
+		return
+	}
+	s.statuses[status]++
+}
+
+// loadReport is the result of a load test for one region, or for all regions.
+type loadReport struct {
+	Region   string          `json:"region"`
+	Requests int             `json:"requests"`
+	Errors   map[string]int  `json:"errors,omitempty"`
+	Statuses map[string]int  `json:"statuses,omitempty"`
+	Rate     float64         `json:"rate"`
+	Latency  *latencySummary `json:"latency,omitempty"`
+}
+
+func runLoad(args []string) error {
+	fs := flag.NewFlagSet("burrow load", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), loadUsage, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	var proxies proxyFlags
+	var headers headerFlags
+	var method, data, weights, format string
+	var rps float64
+	var concurrency, maxInFlight int
+	var duration, timeout time.Duration
+	proxies.register(fs)
+	fs.StringVar(&method, "X", "", "HTTP method (default GET, or POST when -d is set)")
+	fs.Var(&headers, "H", "Request header \"Name: value\" (repeatable)")
+	fs.StringVar(&data, "d", "", "Request body; @file reads a file and @- reads stdin")
+	fs.Float64Var(&rps, "rps", 0, "Requests per second across all regions")
+	fs.IntVar(&concurrency, "c", 0, "Number of concurrent workers (used when -rps is not set)")
+	fs.IntVar(&maxInFlight, "max-in-flight", 1000, "Maximum requests in flight with -rps")
+	fs.DurationVar(&duration, "duration", 30*time.Second, "How long to generate load")
+	fs.DurationVar(&timeout, "timeout", 0, "Upstream request timeout passed to the proxy")
+	fs.StringVar(&weights, "weights", "", "Region weights as region=weight,... or a JSON weights file")
+	fs.StringVar(&format, "format", "text", "Output format: text or json")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one URL is required")
+	}
+	if (rps > 0) == (concurrency > 0) {
+		return errors.New("exactly one of -rps and -c is required")
+	}
+	if rps > 0 && rateInterval(rps) <= 0 {
+		return fmt.Errorf("-rps is too high: %g", rps)
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format: %s", format)
+	}
+	body, err := readData(data)
+	if err != nil {
+		return err
+	}
+	if method == "" {
+		method = "GET"
+		if body != nil {
+			method = "POST"
+		}
+	}
+	template, err := http.NewRequest(strings.ToUpper(method), fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		template.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	targets, err := loadTargets(&proxies, weights, timeout)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	l := &loadGenerator{
+		template: template,
+		body:     body,
+		targets:  targets,
+		picker:   newWeightedPicker(targets),
+	}
+	start := time.Now()
+	if rps > 0 {
+		l.runRate(ctx, rps, duration, maxInFlight)
+	} else {
+		l.runConcurrent(ctx, concurrency, duration)
+	}
+	elapsed := time.Since(start)
+
+	reports := l.reports(elapsed)
+	if format == "json" {
+		return writeJSON(reports)
+	}
+	printLoadReports(os.Stdout, reports)
+	return nil
+}
+
+// loadTargets creates a transport for each selected proxy with a non-zero
+// weight. Each proxy has a weight of 1 unless weights are given, in which
+// case proxies without a weight are not used.
+func loadTargets(proxies *proxyFlags, weights string, timeout time.Duration) ([]*loadTarget, error) {
+	selected, err := proxies.load()
+	if err != nil {
+		return nil, err
+	}
+	var parsed map[string]float64
+	if weights != "" {
+		if parsed, err = parseWeights(weights); err != nil {
+			return nil, err
+		}
+		for name := range parsed {
+			if _, ok := selected[name]; !ok {
+				return nil, fmt.Errorf("weight given for unknown region: %s", name)
+			}
+		}
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: 1000,
+			IdleConnTimeout:     90 * time.Second,
+			ForceAttemptHTTP2:   true,
+		},
+	}
+	var targets []*loadTarget
+	for _, name := range sortedKeys(selected) {
+		weight := 1.0
+		if parsed != nil {
+			weight = parsed[name]
+		}
+		if weight <= 0 {
+			continue
+		}
+		transport := burrow.NewTransportWithClient(selected[name], "POST", client)
+		if timeout > 0 {
+			transport.WithTimeout(timeout)
+		}
+		targets = append(targets, &loadTarget{
+			name:      name,
+			transport: transport,
+			weight:    weight,
+			stats:     &loadStats{statuses: map[int]int{}, errors: map[string]int{}},
+		})
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no regions have a positive weight")
+	}
+	return targets, nil
+}
+
+// parseWeights parses region weights given as "region=weight,..." or as the
+// path to a JSON file mapping regions to weights, as written by burrow probe.
+func parseWeights(value string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	if data, err := os.ReadFile(value); err == nil {
+		if err := json.Unmarshal(data, &weights); err != nil {
+			return nil, fmt.Errorf("invalid weights file %s: %w", value, err)
+		}
+		return weights, nil
+	}
+	for _, item := range splitList(value) {
+		name, w, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid weight %q (expected region=weight)", item)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(w), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q", item)
+		}
+		weights[strings.TrimSpace(name)] = weight
+	}
+	return weights, nil
+}
+
+// weightedPicker picks targets at random in proportion to their weights.
+type weightedPicker struct {
+	mutex      sync.Mutex
+	rand       *rand.Rand
+	targets    []*loadTarget
+	cumulative []float64
+}
+
+func newWeightedPicker(targets []*loadTarget) *weightedPicker {
+	p := &weightedPicker{
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		targets: targets,
+	}
+	var total float64
+	for _, t := range targets {
+		total += t.weight
+		p.cumulative = append(p.cumulative, total)
+	}
+	return p
+}
+
+func (p *weightedPicker) pick() *loadTarget {
+	p.mutex.Lock()
+	n := p.rand.Float64() * p.cumulative[len(p.cumulative)-1]
+	p.mutex.Unlock()
+	for i, c := range p.cumulative {
+		if n < c {
+			return p.targets[i]
+		}
+	}
+	return p.targets[len(p.targets)-1]
+}
+
+// loadGenerator sends copies of a template request through weighted targets.
+type loadGenerator struct {
+	template *http.Request
+	body     []byte
+	targets  []*loadTarget
+	picker   *weightedPicker
+}
+
+// rateInterval returns the time between requests sent at rps, which is zero
+// if the rate is too high to schedule.
+func rateInterval(rps float64) time.Duration {
+	return time.Duration(float64(time.Second) / rps)
+}
+
+// runRate sends requests at a fixed rate. Latency is measured from when each
+// request was scheduled, so time spent waiting for a free slot counts.
+func (l *loadGenerator) runRate(ctx context.Context, rps float64, duration time.Duration, maxInFlight int) {
+	interval := rateInterval(rps)
+	start := time.Now()
+	end := start.Add(duration)
+	sem := make(chan struct{}, maxInFlight)
+	var wg sync.WaitGroup
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for i := 0; ; i++ {
+		scheduled := start.Add(time.Duration(i) * interval)
+		if !scheduled.Before(end) {
+			break
+		}
+		if wait := time.Until(scheduled); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			}
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(scheduled time.Time) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			l.send(ctx, l.picker.pick(), scheduled)
+		}(scheduled)
+	}
+	wg.Wait()
+}
+
+// runConcurrent sends requests back to back from a fixed number of workers.
+func (l *loadGenerator) runConcurrent(ctx context.Context, concurrency int, duration time.Duration) {
+	end := time.Now().Add(duration)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(end) && ctx.Err() == nil {
+				l.send(ctx, l.picker.pick(), time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (l *loadGenerator) send(ctx context.Context, target *loadTarget, scheduled time.Time) {
+	req := l.template.Clone(ctx)
+	if l.body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(l.body))
+		req.ContentLength = int64(len(l.body))
+	}
+	resp, err := target.transport.RoundTrip(req)
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	// Requests interrupted by the user are not counted
+	if ctx.Err() != nil {
+		return
+	}
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	target.stats.record(time.Since(scheduled), status, err)
+}
+
+// reports returns a report for each region followed by a total.
+func (l *loadGenerator) reports(elapsed time.Duration) []*loadReport {
+	total := &loadReport{Region: "total", Errors: map[string]int{}, Statuses: map[string]int{}}
+	var all []time.Duration
+	var reports []*loadReport
+	for _, target := range l.targets {
+		stats := target.stats
+		stats.mutex.Lock()
+		report := &loadReport{
+			Region:   target.name,
+			Requests: len(stats.latencies),
+			Errors:   map[string]int{},
+			Statuses: map[string]int{},
+			Rate:     float64(len(stats.latencies)) / elapsed.Seconds(),
+			Latency:  summarizeLatencies(stats.latencies),
+		}
+		for status, n := range stats.statuses {
+			report.Statuses[strconv.Itoa(status)] += n
+			total.Statuses[strconv.Itoa(status)] += n
+		}
+		for name, n := range stats.errors {
+			report.Errors[name] += n
+			total.Errors[name] += n
+		}
+		all = append(all, stats.latencies...)
+		stats.mutex.Unlock()
+		total.Requests += report.Requests
+		reports = append(reports, report)
+	}
+	total.Rate = float64(total.Requests) / elapsed.Seconds()
+	total.Latency = summarizeLatencies(all)
+	return append(reports, total)
+}
+
+func printLoadReports(w io.Writer, reports []*loadReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGION\tREQUESTS\tRATE\tP50\tP90\tP99\tP99.9\tMAX\tSTATUS\tERRORS")
+	for _, r := range reports {
+		latency := r.Latency
+		if latency == nil {
+			latency = &latencySummary{}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f/s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Region, r.Requests, r.Rate,
+			formatSeconds(latency.P50), formatSeconds(latency.P90), formatSeconds(latency.P99),
+			formatSeconds(latency.P999), formatSeconds(latency.Max),
+			formatCounts(r.Statuses), formatCounts(r.Errors))
+	}
+	tw.Flush()
+}
+
+func formatSeconds(s float64) string {
+	if s == 0 {
+		return "-"
+	}
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond / 10).String()
+}
+
+func formatCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "-"
+	}
+	var parts []string
+	for _, k := range sortedKeys(counts) {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, counts[k]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newSlowLoadGenerator returns a load generator with one target that takes
+// delay to respond to each request.
+func newSlowLoadGenerator(t *testing.T, delay time.Duration) *loadGenerator {
+	template, err := http.NewRequest("GET", "https://example.com", nil)
+	require.NoError(t, err)
+	target := &loadTarget{
+		name:   "slow",
+		weight: 1,
+		transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			time.Sleep(delay)
+			return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+		}),
+		stats: &loadStats{statuses: map[int]int{}, errors: map[string]int{}},
+	}
+	targets := []*loadTarget{target}
+	return &loadGenerator{template: template, targets: targets, picker: newWeightedPicker(targets)}
+}
+
+func TestLoadGenerator_RateCountsQueueing(t *testing.T) {
+	// 10 requests are scheduled 10ms apart, but only one can be in flight and
+	// each takes 30ms, so the backlog grows by 20ms per request
+	l := newSlowLoadGenerator(t, 30*time.Millisecond)
+	l.runRate(context.Background(), 100, 100*time.Millisecond, 1)
+
+	stats := l.targets[0].stats
+	require.Len(t, stats.latencies, 10)
+	assert.Equal(t, map[int]int{200: 10}, stats.statuses)
+	slices.Sort(stats.latencies)
+	assert.GreaterOrEqual(t, stats.latencies[0], 30*time.Millisecond)
+	// The last request was scheduled at 90ms and finished after 300ms
+	assert.GreaterOrEqual(t, stats.latencies[9], 200*time.Millisecond)
+}
+
+func TestLoadGenerator_ConcurrentMeasuresServiceTime(t *testing.T) {
+	l := newSlowLoadGenerator(t, 20*time.Millisecond)
+	l.runConcurrent(context.Background(), 2, 100*time.Millisecond)
+
+	stats := l.targets[0].stats
+	require.NotEmpty(t, stats.latencies)
+	for _, latency := range stats.latencies {
+		assert.GreaterOrEqual(t, latency, 20*time.Millisecond)
+		assert.Less(t, latency, 200*time.Millisecond)
+	}
+}
+
+func TestLoadGenerator_Reports(t *testing.T) {
+	l := newSlowLoadGenerator(t, 0)
+	stats := l.targets[0].stats
+	stats.record(10*time.Millisecond, 200, nil)
+	stats.record(20*time.Millisecond, 503, nil)
+	stats.record(30*time.Millisecond, 0, errors.New("connection refused"))
+
+	reports := l.reports(time.Second)
+	require.Len(t, reports, 2)
+	assert.Equal(t, "slow", reports[0].Region)
+	assert.Equal(t, 3, reports[0].Requests)
+	assert.Equal(t, 3.0, reports[0].Rate)
+	assert.Equal(t, map[string]int{"200": 1, "503": 1}, reports[0].Statuses)
+	assert.Equal(t, map[string]int{"transport": 1}, reports[0].Errors)
+	assert.Equal(t, "total", reports[1].Region)
+	assert.Equal(t, 0.02, reports[1].Latency.P50)
+}
+
+func TestParseWeights(t *testing.T) {
+	weights, err := parseWeights("us-east-1=2, eu-west-1=0.5")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"us-east-1": 2, "eu-west-1": 0.5}, weights)
+
+	_, err = parseWeights("us-east-1")
+	assert.Error(t, err)
+	_, err = parseWeights("us-east-1=-1")
+	assert.Error(t, err)
+}
+
+func TestWeightedPicker(t *testing.T) {
+	targets := []*loadTarget{{name: "a", weight: 3}, {name: "b", weight: 1}}
+	picker := newWeightedPicker(targets)
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		counts[picker.pick().name]++
+	}
+	assert.InDelta(t, 3000, counts["a"], 200)
+	assert.InDelta(t, 1000, counts["b"], 200)
+}
+
+func TestRunLoad_RateTooHigh(t *testing.T) {
+	assert.Equal(t, time.Millisecond, rateInterval(1000))
+	assert.Zero(t, rateInterval(2e9))
+	err := runLoad([]string{"-rps", "2e9", "https://example.com"})
+	assert.EqualError(t, err, "-rps is too high: 2e+09")
+}
@@ -0,0 +1,37 @@
+// Command burrow sends HTTP requests through Burrow proxies from the command
+// line.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Usage:
+  burrow [flags] URL          Send a request through the proxies
+  burrow fetch [flags] JOBS   Fetch a JSONL file of requests
+  burrow load [flags] URL     Generate load through the proxies
+  burrow probe [flags]        Benchmark each proxy with an echo target
+
+Proxies are read from -proxy, the BURROW_PROXY_URLS environment variable
+(comma-separated), or a JSON file mapping region names to Function URLs
+(-functions, BURROW_FUNCTIONS, default ./function_urls.json).
+`
+
+// commands maps subcommand names to their entrypoints.
+var commands = map[string]func(args []string) error{}
+
+func main() {
+	args := os.Args[1:]
+	run := runRequest
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			run = cmd
+			args = args[1:]
+		}
+	}
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, "burrow:", err)
+		os.Exit(1)
+	}
+}
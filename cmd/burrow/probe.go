@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/myzie/burrow"
+)
+
+func init() {
+	commands["probe"] = runProbe
+}
+
+const probeUsage = `Usage:
+  burrow probe [flags]
+
+Sends -n sequential requests to an echo target through each proxy and reports
+the latency of the first request (cold, if the function was idle) and of the
+remaining warm requests, the egress IP addresses seen by the target, the time
+spent upstream versus in the proxy, and the error rate.
+
+With -retire, each request asks the proxy to retire its execution environment
+after responding, so that every request should be served by a new environment
+and the number of distinct egress IPs and environments shows whether
+rotation is working.
+
+With -weights-out, a JSON file mapping regions to weights is written for use
+with burrow load -weights. Weights favor fast regions without errors.
+`
+
+const defaultEchoURL = "https://api.ipify.org?format=json"
+
+// probeReport is the result of probing one proxy.
+type probeReport struct {
+	Region       string          `json:"region"`
+	ProxyURL     string          `json:"proxy_url"`
+	ProxyName    string          `json:"proxy_name,omitempty"`
+	Requests     int             `json:"requests"`
+	Errors       map[string]int  `json:"errors,omitempty"`
+	ErrorRate    float64         `json:"error_rate"`
+	Cold         float64         `json:"cold,omitempty"`
+	Warm         *latencySummary `json:"warm,omitempty"`
+	Upstream     float64         `json:"upstream,omitempty"`
+	Overhead     float64         `json:"overhead,omitempty"`
+	EgressIPs    []string        `json:"egress_ips,omitempty"`
+	Environments int             `json:"environments,omitempty"`
+}
+
+func runProbe(args []string) error {
+	fs := flag.NewFlagSet("burrow probe", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), probeUsage, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	defaultTarget := os.Getenv("BURROW_ECHO_URL")
+	if defaultTarget == "" {
+		defaultTarget = defaultEchoURL
+	}
+	var proxies proxyFlags
+	var target, weightsOut, format string
+	var count int
+	var timeout time.Duration
+	var retire bool
+	proxies.register(fs)
+	fs.StringVar(&target, "target", defaultTarget, "Echo URL that returns the caller's IP address")
+	fs.IntVar(&count, "n", 5, "Number of requests per proxy")
+	fs.DurationVar(&timeout, "timeout", 10*time.Second, "Upstream request timeout passed to the proxy")
+	fs.BoolVar(&retire, "retire", false, "Ask the proxy to retire its execution environment after each request")
+	fs.StringVar(&weightsOut, "weights-out", "", "Write region weights to this JSON file")
+	fs.StringVar(&format, "format", "text", "Output format: text or json")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("unexpected arguments")
+	}
+	if count <= 0 {
+		return errors.New("-n must be positive")
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format: %s", format)
+	}
+	selected, err := proxies.load()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	names := sortedKeys(selected)
+	reports := make([]*probeReport, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			reports[i] = probe(ctx, name, selected[name], target, count, timeout, retire)
+		}(i, name)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if weightsOut != "" {
+		data, err := json.MarshalIndent(probeWeights(reports), "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(weightsOut, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+	if format == "json" {
+		return writeJSON(reports)
+	}
+	printProbeReports(os.Stdout, reports)
+	return nil
+}
+
+// probe sends count sequential requests through one proxy, so that the first
+// request may start a new execution environment and the rest reuse it.
+func probe(ctx context.Context, name, proxyURL, target string, count int, timeout time.Duration, retire bool) *probeReport {
+	transport := burrow.NewTransport(proxyURL, "POST").WithTimeout(timeout)
+	report := &probeReport{Region: name, ProxyURL: proxyURL, Errors: map[string]int{}}
+	var warm []time.Duration
+	var upstream, overhead time.Duration
+	var successes int
+	ips := map[string]bool{}
+	environments := map[string]bool{}
+	reqCtx := ctx
+	if retire {
+		reqCtx = burrow.RetireEnvironment(ctx)
+	}
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		report.Requests++
+		req, err := http.NewRequestWithContext(reqCtx, "GET", target, nil)
+		if err != nil {
+			report.Errors[errorName(err)]++
+			continue
+		}
+		start := time.Now()
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			report.Errors[errorName(err)]++
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		latency := time.Since(start)
+		if err != nil {
+			report.Errors[errorName(err)]++
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			report.Errors[fmt.Sprintf("status:%d", resp.StatusCode)]++
+			continue
+		}
+		if i == 0 {
+			report.Cold = latency.Seconds()
+		} else {
+			warm = append(warm, latency)
+		}
+		ip := parseEgressIP(body)
+		if md := burrow.ResponseMetadata(resp); md != nil {
+			report.ProxyName = md.ProxyName
+			upstream += md.Duration
+			overhead += md.RoundTrip - md.Duration
+			if md.EnvironmentID != "" {
+				environments[md.EnvironmentID] = true
+			}
+			if ip == "" {
+				ip = md.EgressIP
+			}
+		}
+		successes++
+		if ip != "" {
+			ips[ip] = true
+		}
+	}
+	report.ErrorRate = float64(report.Requests-successes) / float64(report.Requests)
+	report.Warm = summarizeLatencies(warm)
+	if successes > 0 {
+		report.Upstream = (upstream / time.Duration(successes)).Seconds()
+		report.Overhead = (overhead / time.Duration(successes)).Seconds()
+	}
+	report.EgressIPs = sortedKeys(ips)
+	report.Environments = len(environments)
+	if len(report.Errors) == 0 {
+		report.Errors = nil
+	}
+	return report
+}
+
+// parseEgressIP returns the IP address in an echo response, which is either
+// JSON with an "ip" or "origin" field, or the address as plain text.
+func parseEgressIP(body []byte) string {
+	var echo struct {
+		IP     string `json:"ip"`
+		Origin string `json:"origin"`
+	}
+	value := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &echo); err == nil {
+		value = echo.IP
+		if value == "" {
+			// httpbin reports a comma-separated list of forwarded addresses
+			value, _, _ = strings.Cut(echo.Origin, ",")
+		}
+	}
+	value = strings.TrimSpace(value)
+	if net.ParseIP(value) == nil {
+		return ""
+	}
+	return value
+}
+
+// probeWeights returns a weight for each region that is inversely
+// proportional to its warm median latency and scaled by its success rate,
+// normalized so that the weights sum to 1.
+func probeWeights(reports []*probeReport) map[string]float64 {
+	weights := map[string]float64{}
+	var total float64
+	for _, r := range reports {
+		latency := r.Cold
+		if r.Warm != nil {
+			latency = r.Warm.P50
+		}
+		if latency <= 0 || r.ErrorRate >= 1 {
+			weights[r.Region] = 0
+			continue
+		}
+		weights[r.Region] = (1 - r.ErrorRate) / latency
+		total += weights[r.Region]
+	}
+	for region, w := range weights {
+		if total > 0 {
+			weights[region] = math.Round(w/total*1000) / 1000
+		}
+	}
+	return weights
+}
+
+func printProbeReports(w io.Writer, reports []*probeReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGION\tOK\tCOLD\tWARM P50\tWARM MAX\tUPSTREAM\tOVERHEAD\tENVS\tEGRESS IP\tERRORS")
+	for _, r := range reports {
+		warm := r.Warm
+		if warm == nil {
+			warm = &latencySummary{}
+		}
+		ips := "-"
+		if len(r.EgressIPs) > 0 {
+			ips = strings.Join(r.EgressIPs, ",")
+		}
+		ok := r.Requests - sumCounts(r.Errors)
+		fmt.Fprintf(tw, "%s\t%d/%d\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			r.Region, ok, r.Requests,
+			formatSeconds(r.Cold), formatSeconds(warm.P50), formatSeconds(warm.Max),
+			formatSeconds(r.Upstream), formatSeconds(r.Overhead),
+			r.Environments, ips, formatCounts(r.Errors))
+	}
+	tw.Flush()
+}
+
+func sumCounts(counts map[string]int) int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/myzie/burrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEchoProxy returns a mock proxy that answers every request with a new
+// egress IP and environment if it asks to retire, and the same ones otherwise.
+// The first failures requests fail with a 502.
+func newEchoProxy(t *testing.T, failures int) *httptest.Server {
+	var calls, environment atomic.Int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req burrow.Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if calls.Add(1) <= int64(failures) {
+			json.NewEncoder(w).Encode(burrow.Response{StatusCode: http.StatusBadGateway})
+			return
+		}
+		env := environment.Load()
+		if req.Retire {
+			env = environment.Add(1)
+		}
+		ip := fmt.Sprintf("203.0.113.%d", env+1)
+		json.NewEncoder(w).Encode(burrow.Response{
+			StatusCode:    http.StatusOK,
+			Body:          base64.StdEncoding.EncodeToString([]byte(`{"ip":"` + ip + `"}`)),
+			Duration:      0.001,
+			ProxyName:     "mock",
+			EnvironmentID: fmt.Sprintf("env-%d", env),
+		})
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func TestProbe(t *testing.T) {
+	proxy := newEchoProxy(t, 0)
+	report := probe(context.Background(), "us-east-1", proxy.URL, "https://echo.test", 4, time.Second, false)
+
+	assert.Equal(t, "us-east-1", report.Region)
+	assert.Equal(t, "mock", report.ProxyName)
+	assert.Equal(t, 4, report.Requests)
+	assert.Nil(t, report.Errors)
+	assert.Equal(t, 0.0, report.ErrorRate)
+	assert.Greater(t, report.Cold, 0.0)
+	require.NotNil(t, report.Warm)
+	assert.Equal(t, 3, report.Warm.Count)
+	assert.Equal(t, 0.001, report.Upstream)
+	assert.Equal(t, []string{"203.0.113.1"}, report.EgressIPs)
+	assert.Equal(t, 1, report.Environments)
+}
+
+func TestProbe_Retire(t *testing.T) {
+	proxy := newEchoProxy(t, 0)
+	report := probe(context.Background(), "us-east-1", proxy.URL, "https://echo.test", 3, time.Second, true)
+
+	assert.Equal(t, []string{"203.0.113.2", "203.0.113.3", "203.0.113.4"}, report.EgressIPs)
+	assert.Equal(t, 3, report.Environments)
+}
+
+func TestProbe_Errors(t *testing.T) {
+	proxy := newEchoProxy(t, 1)
+	report := probe(context.Background(), "us-east-1", proxy.URL, "https://echo.test", 4, time.Second, false)
+
+	assert.Equal(t, map[string]int{"status:502": 1}, report.Errors)
+	assert.Equal(t, 0.25, report.ErrorRate)
+	// The first successful request is still counted as warm
+	require.NotNil(t, report.Warm)
+	assert.Equal(t, 3, report.Warm.Count)
+	assert.Zero(t, report.Cold)
+}
+
+func TestParseEgressIP(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"ip":"203.0.113.1"}`, "203.0.113.1"},
+		{`{"origin":"203.0.113.1, 10.0.0.1"}`, "203.0.113.1"},
+		{"2001:db8::1\n", "2001:db8::1"},
+		{`{"ip":"not an ip"}`, ""},
+		{"<html></html>", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parseEgressIP([]byte(tt.body)), tt.body)
+	}
+}
+
+func TestProbeWeights(t *testing.T) {
+	weights := probeWeights([]*probeReport{
+		{Region: "fast", Warm: &latencySummary{P50: 0.1}},
+		{Region: "slow", Warm: &latencySummary{P50: 0.3}},
+		{Region: "cold-only", Cold: 0.2, ErrorRate: 0.5},
+		{Region: "down", ErrorRate: 1},
+	})
+	assert.Equal(t, map[string]float64{"fast": 0.632, "slow": 0.211, "cold-only": 0.158, "down": 0}, weights)
+}
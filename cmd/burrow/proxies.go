@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// proxyFlags contains the flags used to select proxies, shared by all
+// commands.
+type proxyFlags struct {
+	proxies   string
+	functions string
+	regions   string
+}
+
+func (p *proxyFlags) register(fs *flag.FlagSet) {
+	defaultFunctions := os.Getenv("BURROW_FUNCTIONS")
+	if defaultFunctions == "" {
+		defaultFunctions = "./function_urls.json"
+	}
+	fs.StringVar(&p.proxies, "proxy", os.Getenv("BURROW_PROXY_URLS"), "Comma-separated proxy URLs (overrides -functions)")
+	fs.StringVar(&p.functions, "functions", defaultFunctions, "Function URLs JSON file mapping regions to URLs")
+	fs.StringVar(&p.regions, "region", "", "Comma-separated regions to use (default all)")
+}
+
+// load returns the selected proxies keyed by name. Proxies given as URLs are
+// named by their URL.
+func (p *proxyFlags) load() (map[string]string, error) {
+	proxies := map[string]string{}
+	if p.proxies != "" {
+		for _, u := range splitList(p.proxies) {
+			proxies[u] = u
+		}
+	} else {
+		functions, err := readFunctionURLs(p.functions)
+		if err != nil {
+			return nil, err
+		}
+		proxies = functions
+	}
+	if p.regions != "" {
+		selected := map[string]string{}
+		for _, region := range splitList(p.regions) {
+			u, ok := proxies[region]
+			if !ok {
+				return nil, fmt.Errorf("unknown region: %s", region)
+			}
+			selected[region] = u
+		}
+		proxies = selected
+	}
+	if len(proxies) == 0 {
+		return nil, errors.New("no proxies configured")
+	}
+	return proxies, nil
+}
+
+// urls returns the URLs of the selected proxies, sorted by name.
+func (p *proxyFlags) urls() ([]string, error) {
+	proxies, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, name := range sortedKeys(proxies) {
+		urls = append(urls, proxies[name])
+	}
+	return urls, nil
+}
+
+func readFunctionURLs(path string) (map[string]string, error) {
+	functions := make(map[string]string)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&functions); err != nil {
+		return nil, fmt.Errorf("invalid function URLs file %s: %w", path, err)
+	}
+	return functions, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
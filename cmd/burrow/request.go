@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/myzie/burrow"
+)
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("invalid header %q (expected \"Name: value\")", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// requestOutput is the JSON output of a request.
+type requestOutput struct {
+	StatusCode  int                 `json:"status_code,omitempty"`
+	Headers     map[string][]string `json:"headers,omitempty"`
+	Body        string              `json:"body,omitempty"`
+	BodyBase64  string              `json:"body_base64,omitempty"`
+	ProxyName   string              `json:"proxy_name,omitempty"`
+	ProxyURL    string              `json:"proxy_url,omitempty"`
+	Duration    float64             `json:"duration,omitempty"`
+	RoundTrip   float64             `json:"round_trip,omitempty"`
+	EgressIP    string              `json:"egress_ip,omitempty"`
+	Diagnostics *burrow.Diagnostics `json:"diagnostics,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	ErrorType   *burrow.ErrorCode   `json:"error_type,omitempty"`
+}
+
+func runRequest(args []string) error {
+	fs := flag.NewFlagSet("burrow", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	var proxies proxyFlags
+	var headers headerFlags
+	var method, data, format string
+	var retries int
+	var timeout time.Duration
+	var include, verbose, diagnostics bool
+	proxies.register(fs)
+	fs.StringVar(&method, "X", "", "HTTP method (default GET, or POST when -d is set)")
+	fs.Var(&headers, "H", "Request header \"Name: value\" (repeatable)")
+	fs.StringVar(&data, "d", "", "Request body; @file reads a file and @- reads stdin")
+	fs.IntVar(&retries, "retries", 0, "Number of retries on retryable status codes")
+	fs.DurationVar(&timeout, "timeout", 0, "Upstream request timeout passed to the proxy")
+	fs.BoolVar(&include, "i", false, "Include response headers in text output")
+	fs.BoolVar(&verbose, "v", false, "Print proxy metadata to stderr in text output")
+	fs.BoolVar(&diagnostics, "diagnostics", false, "Collect upstream connection diagnostics")
+	fs.StringVar(&format, "format", "text", "Output format: text or json")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one URL is required")
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format: %s", format)
+	}
+	proxyURLs, err := proxies.urls()
+	if err != nil {
+		return err
+	}
+	body, err := readData(data)
+	if err != nil {
+		return err
+	}
+	if method == "" {
+		method = "GET"
+		if body != nil {
+			method = "POST"
+		}
+	}
+	req, err := http.NewRequest(strings.ToUpper(method), fs.Arg(0), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	opts := []burrow.ClientOption{
+		burrow.WithProxyURLs(proxyURLs),
+		burrow.WithRetries(retries),
+		burrow.WithDiagnostics(diagnostics),
+	}
+	if timeout > 0 {
+		opts = append(opts, burrow.WithTimeout(timeout))
+	}
+	client := burrow.NewClient(opts...)
+	// The proxy follows up to 5 redirects itself. A redirect that still
+	// reaches the client, e.g. from a proxy with a custom redirect policy, is
+	// returned as is rather than followed locally
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		if format == "json" {
+			writeJSON(errorOutput(err, time.Since(start)))
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	out := newRequestOutput(resp, respBody, time.Since(start))
+	if format == "json" {
+		return writeJSON(out)
+	}
+	if verbose {
+		printMetadata(os.Stderr, out)
+	}
+	if include {
+		proto := resp.Proto
+		if proto == "" {
+			proto = "HTTP/1.1"
+		}
+		fmt.Printf("%s %d %s\n", proto, resp.StatusCode, http.StatusText(resp.StatusCode))
+		printHeaders(os.Stdout, resp.Header)
+		fmt.Println()
+	}
+	_, err = os.Stdout.Write(respBody)
+	return err
+}
+
+// readData returns the request body given by the -d flag.
+func readData(data string) ([]byte, error) {
+	switch {
+	case data == "":
+		return nil, nil
+	case data == "@-":
+		return io.ReadAll(os.Stdin)
+	case strings.HasPrefix(data, "@"):
+		return os.ReadFile(data[1:])
+	default:
+		return []byte(data), nil
+	}
+}
+
+func newRequestOutput(resp *http.Response, body []byte, roundTrip time.Duration) *requestOutput {
+	out := &requestOutput{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Header,
+		RoundTrip:  roundTrip.Seconds(),
+	}
+	if utf8.Valid(body) {
+		out.Body = string(body)
+	} else {
+		out.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	if md := burrow.ResponseMetadata(resp); md != nil {
+		out.ProxyName = md.ProxyName
+		out.ProxyURL = md.ProxyURL
+		out.Duration = md.Duration.Seconds()
+		out.EgressIP = md.EgressIP
+		out.Diagnostics = md.Diagnostics
+	}
+	return out
+}
+
+func errorOutput(err error, roundTrip time.Duration) *requestOutput {
+	out := &requestOutput{Error: err.Error(), RoundTrip: roundTrip.Seconds()}
+	var proxyErr *burrow.ProxyError
+	if errors.As(err, &proxyErr) {
+		out.ErrorType = &proxyErr.Type
+	}
+	return out
+}
+
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printMetadata(w io.Writer, out *requestOutput) {
+	fmt.Fprintf(w, "* proxy: %s (%s)\n", out.ProxyName, out.ProxyURL)
+	fmt.Fprintf(w, "* upstream duration: %.3fs\n", out.Duration)
+	fmt.Fprintf(w, "* round trip: %.3fs\n", out.RoundTrip)
+	if out.EgressIP != "" {
+		fmt.Fprintf(w, "* egress ip: %s\n", out.EgressIP)
+	}
+	if d := out.Diagnostics; d != nil {
+		fmt.Fprintf(w, "* remote address: %s\n", d.RemoteAddr)
+		fmt.Fprintf(w, "* protocol: %s\n", d.Protocol)
+		if d.TLS != nil {
+			fmt.Fprintf(w, "* tls: %s %s\n", d.TLS.VersionName, d.TLS.CipherSuiteName)
+		}
+	}
+}
+
+func printHeaders(w io.Writer, header http.Header) {
+	for _, name := range sortedKeys(header) {
+		for _, value := range header[name] {
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+		}
+	}
+}
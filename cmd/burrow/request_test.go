@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/myzie/burrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyFlags(t *testing.T) {
+	functions := filepath.Join(t.TempDir(), "function_urls.json")
+	require.NoError(t, os.WriteFile(functions, []byte(`{"us-east-1": "https://a", "eu-west-1": "https://b"}`), 0o644))
+
+	tests := []struct {
+		name    string
+		flags   proxyFlags
+		want    []string
+		wantErr string
+	}{
+		{"all regions", proxyFlags{functions: functions}, []string{"https://b", "https://a"}, ""},
+		{"one region", proxyFlags{functions: functions, regions: "us-east-1"}, []string{"https://a"}, ""},
+		{"urls override", proxyFlags{proxies: "https://x, https://y", functions: functions}, []string{"https://x", "https://y"}, ""},
+		{"unknown region", proxyFlags{functions: functions, regions: "ap-south-1"}, nil, "unknown region: ap-south-1"},
+		{"no proxies", proxyFlags{proxies: " , "}, nil, "no proxies configured"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls, err := tt.flags.urls()
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, urls)
+		})
+	}
+}
+
+func TestHeaderFlags(t *testing.T) {
+	var h headerFlags
+	require.NoError(t, h.Set("Accept: text/html"))
+	assert.Error(t, h.Set("no-colon"))
+	assert.Equal(t, headerFlags{"Accept: text/html"}, h)
+}
+
+func TestRequestOutput(t *testing.T) {
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"image/png"}}}
+	out := newRequestOutput(resp, []byte{0xff, 0xfe}, time.Second)
+	assert.Equal(t, "//4=", out.BodyBase64)
+	assert.Empty(t, out.Body)
+	assert.Equal(t, 1.0, out.RoundTrip)
+
+	out = errorOutput(burrow.ProxyErrorf(burrow.ProxyErrTimeout, "timed out"), time.Second)
+	require.NotNil(t, out.ErrorType)
+	assert.Equal(t, burrow.ProxyErrTimeout, *out.ErrorType)
+	assert.Equal(t, "proxy error [4] timed out", out.Error)
+}
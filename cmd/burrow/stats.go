@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/myzie/burrow"
+)
+
+// latencySummary summarizes a set of latencies, in seconds.
+type latencySummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	P999  float64 `json:"p99_9"`
+	Max   float64 `json:"max"`
+}
+
+// summarizeLatencies returns a summary of the latencies, which are sorted in
+// place. Nil is returned if there are no latencies.
+func summarizeLatencies(latencies []time.Duration) *latencySummary {
+	if len(latencies) == 0 {
+		return nil
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	return &latencySummary{
+		Count: len(latencies),
+		Min:   latencies[0].Seconds(),
+		Mean:  (total / time.Duration(len(latencies))).Seconds(),
+		P50:   percentile(latencies, 0.5).Seconds(),
+		P90:   percentile(latencies, 0.9).Seconds(),
+		P99:   percentile(latencies, 0.99).Seconds(),
+		P999:  percentile(latencies, 0.999).Seconds(),
+		Max:   latencies[len(latencies)-1].Seconds(),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies: the
+// smallest latency such that at least p of the latencies are no greater.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	// The epsilon keeps exact ranks such as 0.9*10 from rounding up
+	rank := int(math.Ceil(p*float64(len(sorted))-1e-9)) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return sorted[rank]
+}
+
+var proxyErrorNames = map[burrow.ErrorCode]string{
+	burrow.ProxyErrUnknown:               "unknown",
+	burrow.ProxyErrBadRequest:            "bad_request",
+	burrow.ProxyErrExceededMaxBodySize:   "max_body_size",
+	burrow.ProxyErrDisallowedContentType: "disallowed_content_type",
+	burrow.ProxyErrTimeout:               "timeout",
+	burrow.ProxyErrThrottled:             "throttled",
+	burrow.ProxyErrUnavailable:           "unavailable",
+}
+
+// errorName returns a short name for an error returned by a transport, e.g.
+// "proxy:timeout" or "transport".
+func errorName(err error) string {
+	class := burrow.ClassifyError(err)
+	var proxyErr *burrow.ProxyError
+	if class == burrow.ErrorClassProxy && errors.As(err, &proxyErr) {
+		if name, ok := proxyErrorNames[proxyErr.Type]; ok {
+			return "proxy:" + name
+		}
+	}
+	return string(class)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/myzie/burrow"
+	"github.com/stretchr/testify/assert"
+)
+
+// millis returns the latencies 1ms, 2ms, ..., n ms.
+func millis(n int) []time.Duration {
+	latencies := make([]time.Duration, n)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+	return latencies
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		n    int
+		p    float64
+		want time.Duration
+	}{
+		{1, 0.5, 1 * time.Millisecond},
+		{1, 0.999, 1 * time.Millisecond},
+		{2, 0.5, 1 * time.Millisecond},
+		{4, 0.5, 2 * time.Millisecond},
+		{5, 0.5, 3 * time.Millisecond},
+		{10, 0.9, 9 * time.Millisecond},
+		{10, 0.91, 10 * time.Millisecond},
+		{10, 0.99, 10 * time.Millisecond},
+		{100, 0.5, 50 * time.Millisecond},
+		{100, 0.99, 99 * time.Millisecond},
+		{150, 0.99, 149 * time.Millisecond},
+		{150, 0.5, 75 * time.Millisecond},
+		{1000, 0.999, 999 * time.Millisecond},
+		{1999, 0.999, 1998 * time.Millisecond},
+		{10, 0, 1 * time.Millisecond},
+		{10, 1, 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("p%v of %d", tt.p*100, tt.n), func(t *testing.T) {
+			assert.Equal(t, tt.want, percentile(millis(tt.n), tt.p))
+		})
+	}
+}
+
+func TestSummarizeLatencies(t *testing.T) {
+	assert.Nil(t, summarizeLatencies(nil))
+
+	latencies := millis(10)
+	// Sorted in place
+	latencies[0], latencies[9] = latencies[9], latencies[0]
+	summary := summarizeLatencies(latencies)
+	assert.Equal(t, &latencySummary{
+		Count: 10,
+		Min:   0.001,
+		Mean:  0.0055,
+		P50:   0.005,
+		P90:   0.009,
+		P99:   0.01,
+		P999:  0.01,
+		Max:   0.01,
+	}, summary)
+}
+
+func TestErrorName(t *testing.T) {
+	assert.Equal(t, "proxy:timeout", errorName(burrow.ProxyErrorf(burrow.ProxyErrTimeout, "timed out")))
+	assert.Equal(t, "proxy", errorName(burrow.ProxyErrorf(99, "new type")))
+	assert.Equal(t, "transport", errorName(fmt.Errorf("connection refused")))
+}
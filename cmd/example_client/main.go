@@ -20,6 +20,7 @@ func main() {
 	var allowedContentTypes string
 	var target, proxy, method string
 	flag.StringVar(&target, "url", "", "URL to send a request to")
+	flag.StringVar(&method, "method", "GET", "HTTP method to use")
 	flag.StringVar(&proxy, "proxy", "", "URL of the proxy to use")
 	flag.Int64Var(&maxResponseBytes, "max-response-bytes", 0, "Maximum response body size")
 	flag.DurationVar(&timeoutDur, "timeout", 0, "Timeout")
@@ -1,36 +1,108 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/myzie/burrow"
+	// Registers the browser TLS profiles
+	_ "github.com/myzie/burrow/tlsprofiles"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RequestHandler struct {
 	Burrow burrow.Handler
 	Logger *slog.Logger
+	Tracer trace.Tracer
+	Flush  func(ctx context.Context) error
+	// ProxyName identifies this proxy in responses, e.g. "aws.lambda.us-east-1"
+	ProxyName string
+	// RuntimeAPI is the address of the Lambda Runtime API, used to retire the
+	// execution environment when a request asks for it
+	RuntimeAPI string
+	// AllowRetire honors requests that ask to retire the execution
+	// environment. It is off by default because any caller of a public
+	// Function URL could otherwise force cold starts.
+	AllowRetire bool
+	// Keyring, if set, requires payloads to be encrypted with one of its keys
+	Keyring *burrow.Keyring
 }
 
+// Handle handles a Function URL event. Encrypted payloads are opened and the
+// response is sealed with the same key before it is returned.
 func (h RequestHandler) Handle(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	ctx = withLogSample(ctx)
+	var envelope *burrow.Envelope
+	if h.Keyring != nil {
+		payload, opened, err := h.Keyring.OpenRequest([]byte(request.Body))
+		if err != nil {
+			h.Logger.ErrorContext(ctx, "failed to open envelope", "error", err)
+			return NewGenericErrorResponse(400, err), nil
+		}
+		request.Body, envelope = string(payload), opened
+	}
+	var out events.APIGatewayV2HTTPResponse
+	var retire bool
+	if burrow.IsBatchPayload([]byte(request.Body)) {
+		out, retire = h.handleBatch(ctx, request)
+	} else {
+		out, retire = h.handleRequest(ctx, request)
+	}
+	if h.Keyring != nil {
+		out = h.seal(envelope, out)
+	}
+	if retire && !h.AllowRetire {
+		h.Logger.DebugContext(ctx, "ignoring retire request")
+	} else if retire {
+		// The span has been ended and flushed by now
+		h.retire(ctx, out)
+	}
+	return out, nil
+}
+
+// handleRequest proxies a single request. It also reports whether the request
+// asked to retire the execution environment.
+func (h RequestHandler) handleRequest(ctx context.Context, request events.APIGatewayV2HTTPRequest) (out events.APIGatewayV2HTTPResponse, retire bool) {
 	var burrowReq burrow.Request
 	if err := json.Unmarshal([]byte(request.Body), &burrowReq); err != nil {
-		return NewGenericErrorResponse(400, fmt.Errorf("invalid request body (expected json)")), nil
+		return NewGenericErrorResponse(400, fmt.Errorf("invalid request body (expected json)")), false
 	}
 	if burrowReq.Method == "" {
 		burrowReq.Method = "GET"
 	}
-	proxyName := fmt.Sprintf("aws.lambda.%s", getRegion())
+	retire = burrowReq.Retire
+	proxyName := h.ProxyName
+
+	if h.Flush != nil {
+		defer func() {
+			if err := h.Flush(ctx); err != nil {
+				h.Logger.ErrorContext(ctx, "trace flush error", "error", err)
+			}
+		}()
+	}
+	if h.Tracer != nil {
+		var span trace.Span
+		ctx, span = h.Tracer.Start(burrow.ExtractTraceContext(ctx, &burrowReq), "burrow.handle",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("burrow.proxy.name", proxyName)))
+		defer span.End()
+	}
 
-	h.Logger.Info("request received",
+	h.Logger.InfoContext(ctx, "request received",
 		"proxy_name", proxyName,
-		"url", burrowReq.URL,
+		h.secret("url", burrowReq.URL),
 		"method", burrowReq.Method,
 		"timeout", burrowReq.Timeout,
 		"max_response_bytes", burrowReq.MaxResponseBytes,
@@ -42,11 +114,11 @@ func (h RequestHandler) Handle(ctx context.Context, request events.APIGatewayV2H
 	if err != nil {
 		var proxyErr *burrow.ProxyError
 		if errors.As(err, &proxyErr) {
-			h.Logger.Error("proxy error", "error", err)
-			return NewProxyErrorResponse(proxyErr), nil
+			h.Logger.ErrorContext(ctx, "proxy error", "error_type", proxyErr.Type, h.secret("error", err))
+			return NewProxyErrorResponse(proxyErr), retire
 		}
-		h.Logger.Error("unknown error", "error", err)
-		return NewGenericErrorResponse(500, err), nil
+		h.Logger.ErrorContext(ctx, "unknown error", h.secret("error", err))
+		return NewGenericErrorResponse(500, err), retire
 	}
 
 	response.ClientDetails = &burrow.ClientDetails{
@@ -54,26 +126,153 @@ func (h RequestHandler) Handle(ctx context.Context, request events.APIGatewayV2H
 		UserAgent: request.RequestContext.HTTP.UserAgent,
 	}
 	response.ProxyName = proxyName
+	response.EnvironmentID = burrow.EnvironmentID()
 	responseBody, err := json.Marshal(response)
 	if err != nil {
-		h.Logger.Error("marshalling error", "error", err)
-		return NewGenericErrorResponse(500, err), nil
+		h.Logger.ErrorContext(ctx, "marshalling error", "error", err)
+		return NewGenericErrorResponse(500, err), retire
 	}
 
-	h.Logger.Info("request completed",
+	h.Logger.InfoContext(ctx, "request completed",
 		"proxy_name", proxyName,
-		"url", burrowReq.URL,
+		h.secret("url", burrowReq.URL),
 		"method", burrowReq.Method,
 		"duration", response.Duration,
 		"status_code", response.StatusCode,
+		"egress_ip", response.EgressIP,
 		"body_size", len(responseBody),
-		"content_type", response.Headers["Content-Type"])
+		h.secret("content_type", response.Headers["Content-Type"]))
 
 	return events.APIGatewayV2HTTPResponse{
 		StatusCode: 200,
 		Body:       string(responseBody),
 		Headers:    map[string]string{"Content-Type": "application/json"},
-	}, nil
+	}, retire
+}
+
+// handleBatch proxies a batch of requests. It also reports whether any of
+// the requests asked to retire the execution environment.
+func (h RequestHandler) handleBatch(ctx context.Context, request events.APIGatewayV2HTTPRequest) (out events.APIGatewayV2HTTPResponse, retire bool) {
+	var batch burrow.BatchRequest
+	if err := json.Unmarshal([]byte(request.Body), &batch); err != nil {
+		return NewGenericErrorResponse(400, fmt.Errorf("invalid batch request body (expected json)")), false
+	}
+	proxyName := h.ProxyName
+	for _, req := range batch.Requests {
+		if req != nil && req.Retire {
+			retire = true
+			break
+		}
+	}
+
+	if h.Flush != nil {
+		defer func() {
+			if err := h.Flush(ctx); err != nil {
+				h.Logger.ErrorContext(ctx, "trace flush error", "error", err)
+			}
+		}()
+	}
+	if h.Tracer != nil {
+		// The batch span continues the trace of the first request and links
+		// to the others
+		parent := ctx
+		var links []trace.Link
+		for _, req := range batch.Requests {
+			if req == nil || len(req.TraceContext) == 0 {
+				continue
+			}
+			reqCtx := burrow.ExtractTraceContext(ctx, req)
+			if links == nil {
+				parent, links = reqCtx, []trace.Link{}
+				continue
+			}
+			links = append(links, trace.LinkFromContext(reqCtx))
+		}
+		var span trace.Span
+		ctx, span = h.Tracer.Start(parent, "burrow.handle_batch",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithLinks(links...),
+			trace.WithAttributes(
+				attribute.String("burrow.proxy.name", proxyName),
+				attribute.Int("burrow.batch.size", len(batch.Requests))))
+		defer span.End()
+	}
+
+	h.Logger.InfoContext(ctx, "batch received",
+		"proxy_name", proxyName,
+		"requests", len(batch.Requests),
+		"concurrency", batch.Concurrency,
+		"client_ip", request.RequestContext.HTTP.SourceIP,
+		"user_agent", request.RequestContext.HTTP.UserAgent)
+
+	response, err := h.Burrow.HandleBatch(ctx, &batch)
+	if err != nil {
+		var proxyErr *burrow.ProxyError
+		if errors.As(err, &proxyErr) {
+			h.Logger.ErrorContext(ctx, "proxy error", "error_type", proxyErr.Type, h.secret("error", err))
+			return NewProxyErrorResponse(proxyErr), retire
+		}
+		h.Logger.ErrorContext(ctx, "unknown error", h.secret("error", err))
+		return NewGenericErrorResponse(500, err), retire
+	}
+
+	clientDetails := &burrow.ClientDetails{
+		SourceIP:  request.RequestContext.HTTP.SourceIP,
+		UserAgent: request.RequestContext.HTTP.UserAgent,
+	}
+	var failed int
+	for _, result := range response.Results {
+		if result.Response == nil {
+			failed++
+			continue
+		}
+		result.Response.ClientDetails = clientDetails
+		result.Response.ProxyName = proxyName
+		result.Response.EnvironmentID = burrow.EnvironmentID()
+	}
+	responseBody, err := json.Marshal(response)
+	if err != nil {
+		h.Logger.ErrorContext(ctx, "marshalling error", "error", err)
+		return NewGenericErrorResponse(500, err), retire
+	}
+
+	h.Logger.InfoContext(ctx, "batch completed",
+		"proxy_name", proxyName,
+		"requests", len(batch.Requests),
+		"failed", failed,
+		"duration", response.Duration,
+		"body_size", len(responseBody))
+
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: 200,
+		Body:       string(responseBody),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, retire
+}
+
+// secret returns a log attribute for a value that may contain secrets, such
+// as the target URL or an error that mentions it. It is omitted when payloads
+// are encrypted, so that the logs don't leak what the encryption protects.
+func (h RequestHandler) secret(key string, value any) slog.Attr {
+	if h.Keyring != nil {
+		return slog.Attr{}
+	}
+	return slog.Any(key, value)
+}
+
+// seal encrypts a response with the key of the request. The status code is
+// sealed along with the body, so the sealed response always has status 200.
+func (h RequestHandler) seal(request *burrow.Envelope, response events.APIGatewayV2HTTPResponse) events.APIGatewayV2HTTPResponse {
+	body, err := h.Keyring.SealReply(request, response.StatusCode, []byte(response.Body))
+	if err != nil {
+		h.Logger.Error("failed to seal response", "error", err)
+		return NewGenericErrorResponse(500, errors.New("failed to seal response"))
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: 200,
+		Body:       string(body),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
 }
 
 func NewGenericErrorResponse(statusCode int, err error) events.APIGatewayV2HTTPResponse {
@@ -112,6 +311,37 @@ func NewProxyErrorResponse(proxyErr *burrow.ProxyError) events.APIGatewayV2HTTPR
 	}
 }
 
+// retire delivers the response directly to the Lambda Runtime API and then
+// exits, so that the caller still receives the response but later invocations
+// are served by a new execution environment, typically with a new egress IP.
+// If the response cannot be delivered, the environment is kept and the
+// response is returned normally.
+func (h RequestHandler) retire(ctx context.Context, response events.APIGatewayV2HTTPResponse) {
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok || h.RuntimeAPI == "" {
+		h.Logger.WarnContext(ctx, "retire requested outside of the lambda runtime")
+		return
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		h.Logger.ErrorContext(ctx, "marshalling error", "error", err)
+		return
+	}
+	url := fmt.Sprintf("http://%s/2018-06-01/runtime/invocation/%s/response", h.RuntimeAPI, lc.AwsRequestID)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		h.Logger.ErrorContext(ctx, "failed to retire execution environment", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		h.Logger.ErrorContext(ctx, "failed to retire execution environment", "status_code", resp.StatusCode)
+		return
+	}
+	h.Logger.InfoContext(ctx, "retiring execution environment", "environment_id", burrow.EnvironmentID())
+	os.Exit(0)
+}
+
 func getRegion() string {
 	if region := os.Getenv("AWS_REGION"); region != "" {
 		return region
@@ -119,10 +349,113 @@ func getRegion() string {
 	return os.Getenv("AWS_DEFAULT_REGION")
 }
 
+// newTracerProvider returns an OTLP tracer provider if an OTLP endpoint is
+// configured via the standard OTEL_EXPORTER_OTLP_* environment variables.
+func newTracerProvider(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" &&
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter)), nil
+}
+
+// newHeaderPolicy returns the policy for headers sent upstream, configured
+// with BURROW_HEADER_POLICY=anonymous and the comma separated
+// BURROW_HEADERS_REMOVE and BURROW_HEADERS_ALLOW lists, plus headers to set as
+// a JSON object in BURROW_HEADERS_SET. It returns nil if none are set.
+func newHeaderPolicy() (*burrow.HeaderPolicy, error) {
+	var policy burrow.HeaderPolicy
+	switch preset := os.Getenv("BURROW_HEADER_POLICY"); preset {
+	case "":
+	case "anonymous":
+		policy = *burrow.AnonymousHeaderPolicy()
+	default:
+		return nil, fmt.Errorf("unknown header policy: %s", preset)
+	}
+	policy.Remove = append(policy.Remove, splitEnvList("BURROW_HEADERS_REMOVE")...)
+	policy.Allow = append(policy.Allow, splitEnvList("BURROW_HEADERS_ALLOW")...)
+	if value := os.Getenv("BURROW_HEADERS_SET"); value != "" {
+		if err := json.Unmarshal([]byte(value), &policy.Set); err != nil {
+			return nil, fmt.Errorf("BURROW_HEADERS_SET must be a JSON object: %w", err)
+		}
+	}
+	if len(policy.Remove) == 0 && len(policy.Allow) == 0 && len(policy.Set) == 0 {
+		return nil, nil
+	}
+	return &policy, nil
+}
+
 func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// Redact secrets such as API keys in URLs from all logs
+	policy, err := redactionPolicyFromEnv()
+	if err != nil {
+		logger.Error("invalid log redaction policy", "error", err)
+		os.Exit(1)
+	}
+	logger = slog.New(newRedactingHandler(logger.Handler(), policy))
+	mode, err := detectMode()
+	if err != nil {
+		logger.Error("failed to detect runtime", "error", err)
+		os.Exit(1)
+	}
+	if mode == modeEmulator {
+		// The emulator starts this binary again with the Runtime API set
+		err := runEmulator()
+		logger.Error("failed to start runtime interface emulator", "error", err)
+		os.Exit(1)
+	}
+	handler := burrow.GetHandler()
+	// Report the egress IP of each execution environment when enabled
+	if os.Getenv("BURROW_EGRESS_IP") == "true" {
+		resolver := burrow.NewEgressIPResolver(os.Getenv("BURROW_EGRESS_ECHO_URL"))
+		handler = handler.WithEgressIP(resolver)
+	}
+	headerPolicy, err := newHeaderPolicy()
+	if err != nil {
+		logger.Error("invalid header policy", "error", err)
+		os.Exit(1)
+	}
+	if headerPolicy != nil {
+		handler = handler.WithHeaderPolicy(headerPolicy)
+	}
 	h := RequestHandler{
-		Burrow: burrow.GetHandler(),
-		Logger: slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Burrow:      handler,
+		Logger:      logger,
+		RuntimeAPI:  os.Getenv("AWS_LAMBDA_RUNTIME_API"),
+		AllowRetire: os.Getenv("BURROW_ALLOW_RETIRE") == "true",
+		ProxyName:   getProxyName(mode),
+	}
+	// Require encrypted payloads when keys are configured
+	if keys := os.Getenv("BURROW_ENCRYPTION_KEYS"); keys != "" {
+		if h.Keyring, err = burrow.ParseKeyring(keys); err != nil {
+			logger.Error("invalid encryption keys", "error", err)
+			os.Exit(1)
+		}
+	}
+	tp, err := newTracerProvider(context.Background())
+	if err != nil {
+		logger.Error("failed to configure tracing", "error", err)
+	} else if tp != nil {
+		h.Tracer = tp.Tracer("github.com/myzie/burrow/lambda")
+		defer tp.Shutdown(context.Background())
+		if mode == modeLambda {
+			// Spans must be exported before the execution environment is frozen
+			h.Flush = tp.ForceFlush
+		}
+	}
+	if mode == modeHTTP {
+		addr := ":" + getPort()
+		logger.Info("serving http", "addr", addr, "proxy_name", h.ProxyName)
+		if err := serveHTTP(h, addr); err != nil {
+			logger.Error("http server error", "error", err)
+			os.Exit(1)
+		}
+		return
 	}
 	lambda.Start(h.Handle)
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/myzie/burrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestHandler_Retire(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowRetire bool
+		body        string
+		want        string
+	}{
+		{"ignored by default", false, `{"url":"https://example.com","retire":true}`, "ignoring retire request"},
+		{"allowed", true, `{"url":"https://example.com","retire":true}`, "retire requested outside of the lambda runtime"},
+		{"batch ignored by default", false, `{"requests":[{"url":"https://example.com","retire":true}]}`, "ignoring retire request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			h := RequestHandler{
+				Burrow: func(ctx context.Context, req *burrow.Request) (*burrow.Response, error) {
+					return &burrow.Response{StatusCode: 200}, nil
+				},
+				Logger:      slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+				AllowRetire: tt.allowRetire,
+			}
+			out, err := h.Handle(context.Background(), events.APIGatewayV2HTTPRequest{Body: tt.body})
+			require.NoError(t, err)
+			assert.Equal(t, 200, out.StatusCode)
+			assert.Contains(t, logs.String(), tt.want)
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// defaultRedactParams are the query parameters redacted unless
+// BURROW_LOG_REDACT_PARAMS is set, since they commonly carry credentials.
+var defaultRedactParams = []string{
+	"key", "api_key", "apikey", "token", "access_token", "auth", "password",
+	"secret", "client_secret", "sig", "signature", "x-amz-signature",
+	"x-amz-security-token", "x-amz-credential",
+}
+
+// urlPattern matches URLs embedded in log values, e.g. in error messages.
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// redactionPolicy controls how values that may contain secrets are written to
+// the logs. Passwords in URLs are always redacted.
+type redactionPolicy struct {
+	// stripQuery removes the query and fragment from URLs
+	stripQuery bool
+	// redactParams are query parameters whose values are redacted
+	redactParams map[string]bool
+	// hashURLs replaces URLs with a hash, so requests for the same URL can be
+	// correlated without logging it
+	hashURLs bool
+	hashKey  []byte
+	// drop are attribute keys that are removed, e.g. "client_ip"
+	drop map[string]bool
+	// sampleRate is the fraction of requests whose info and debug logs are
+	// written. Warnings and errors are always written.
+	sampleRate float64
+}
+
+// redactionPolicyFromEnv reads the policy from the environment:
+//
+//	BURROW_LOG_STRIP_QUERY=true        remove query strings from URLs
+//	BURROW_LOG_REDACT_PARAMS=key,token redact these query parameters instead
+//	                                   of defaultRedactParams ("" for none)
+//	BURROW_LOG_HASH_URLS=true          log a hash of each URL instead
+//	BURROW_LOG_HASH_KEY=secret         key the URL hash (required to hash)
+//	BURROW_LOG_DROP=client_ip,user_agent  remove these log fields
+//	BURROW_LOG_SAMPLE_RATE=0.1         log 10% of successful requests
+func redactionPolicyFromEnv() (*redactionPolicy, error) {
+	p := &redactionPolicy{
+		stripQuery:   os.Getenv("BURROW_LOG_STRIP_QUERY") == "true",
+		redactParams: map[string]bool{},
+		hashURLs:     os.Getenv("BURROW_LOG_HASH_URLS") == "true",
+		hashKey:      []byte(os.Getenv("BURROW_LOG_HASH_KEY")),
+		drop:         map[string]bool{},
+		sampleRate:   1,
+	}
+	if p.hashURLs && len(p.hashKey) == 0 {
+		// An unkeyed hash of a URL can be reversed by hashing guesses
+		return nil, fmt.Errorf("BURROW_LOG_HASH_KEY is required with BURROW_LOG_HASH_URLS")
+	}
+	redactParams := defaultRedactParams
+	if _, ok := os.LookupEnv("BURROW_LOG_REDACT_PARAMS"); ok {
+		redactParams = splitEnvList("BURROW_LOG_REDACT_PARAMS")
+	}
+	for _, param := range redactParams {
+		p.redactParams[strings.ToLower(param)] = true
+	}
+	for _, key := range splitEnvList("BURROW_LOG_DROP") {
+		p.drop[key] = true
+	}
+	if value := os.Getenv("BURROW_LOG_SAMPLE_RATE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("BURROW_LOG_SAMPLE_RATE must be between 0 and 1: %s", value)
+		}
+		p.sampleRate = rate
+	}
+	return p, nil
+}
+
+func splitEnvList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// redactString redacts the URLs in a log value.
+func (p *redactionPolicy) redactString(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	return urlPattern.ReplaceAllStringFunc(s, p.redactURL)
+}
+
+func (p *redactionPolicy) redactURL(raw string) string {
+	if p.hashURLs {
+		mac := hmac.New(sha256.New, p.hashKey)
+		mac.Write([]byte(raw))
+		return "sha256:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		// Don't risk logging a query that can't be parsed
+		if before, _, ok := strings.Cut(raw, "?"); ok && (p.stripQuery || len(p.redactParams) > 0) {
+			return before + "?" + redacted
+		}
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	if p.stripQuery {
+		u.RawQuery = ""
+		u.Fragment = ""
+		u.RawFragment = ""
+	} else if len(p.redactParams) > 0 && u.RawQuery != "" {
+		u.RawQuery = p.redactQuery(u.RawQuery)
+	}
+	return u.String()
+}
+
+// redactQuery redacts parameter values, preserving the order of parameters.
+func (p *redactionPolicy) redactQuery(query string) string {
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil && p.redactParams[strings.ToLower(name)] {
+			params[i] = key + "=" + redacted
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func (p *redactionPolicy) redactAttr(a slog.Attr) slog.Attr {
+	if p.drop[a.Key] {
+		return slog.Attr{}
+	}
+	value := a.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, p.redactString(value.String()))
+	case slog.KindGroup:
+		var attrs []slog.Attr
+		for _, attr := range value.Group() {
+			if attr = p.redactAttr(attr); !attr.Equal(slog.Attr{}) {
+				attrs = append(attrs, attr)
+			}
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(attrs...)}
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(a.Key, p.redactString(v.Error()))
+		case fmt.Stringer:
+			// e.g. a *url.URL
+			return slog.String(a.Key, p.redactString(v.String()))
+		default:
+			// Other values are only rewritten if they may contain a URL
+			if s := fmt.Sprint(v); strings.Contains(s, "://") {
+				return slog.String(a.Key, p.redactString(s))
+			}
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: value}
+}
+
+type logSampleKey struct{}
+
+// withLogSample returns a context whose logs are sampled together, so that
+// either all or none of the info logs for a request are written.
+func withLogSample(ctx context.Context) context.Context {
+	return context.WithValue(ctx, logSampleKey{}, rand.Float64())
+}
+
+func (p *redactionPolicy) sampled(ctx context.Context, level slog.Level) bool {
+	if p.sampleRate >= 1 || level >= slog.LevelWarn {
+		return true
+	}
+	sample, ok := ctx.Value(logSampleKey{}).(float64)
+	if !ok {
+		sample = rand.Float64()
+	}
+	return sample < p.sampleRate
+}
+
+// redactingHandler is a slog.Handler that applies a redaction policy to
+// records before they are written by another handler.
+type redactingHandler struct {
+	handler slog.Handler
+	policy  *redactionPolicy
+}
+
+func newRedactingHandler(handler slog.Handler, policy *redactionPolicy) slog.Handler {
+	return &redactingHandler{handler: handler, policy: policy}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if !h.policy.sampled(ctx, record.Level) {
+		return nil
+	}
+	redactedRecord := slog.NewRecord(record.Time, record.Level, h.policy.redactString(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		if a = h.policy.redactAttr(a); !a.Equal(slog.Attr{}) {
+			redactedRecord.AddAttrs(a)
+		}
+		return true
+	})
+	return h.handler.Handle(ctx, redactedRecord)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var redactedAttrs []slog.Attr
+	for _, a := range attrs {
+		if a = h.policy.redactAttr(a); !a.Equal(slog.Attr{}) {
+			redactedAttrs = append(redactedAttrs, a)
+		}
+	}
+	return &redactingHandler{handler: h.handler.WithAttrs(redactedAttrs), policy: h.policy}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{handler: h.handler.WithGroup(name), policy: h.policy}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Runtime modes, selected with BURROW_MODE or detected from the environment.
+const (
+	// modeLambda serves invocations from the Lambda Runtime API, either in
+	// AWS Lambda (zip or container image) or under the emulator
+	modeLambda = "lambda"
+	// modeEmulator starts the Lambda Runtime Interface Emulator, which then
+	// runs this binary in modeLambda
+	modeEmulator = "rie"
+	// modeHTTP serves proxy requests on PORT, e.g. on Cloud Run
+	modeHTTP = "http"
+)
+
+const defaultEmulatorPath = "/usr/local/bin/aws-lambda-rie"
+
+const defaultPort = "8080"
+
+// maxRequestBodyBytes limits the size of requests in modeHTTP. It is the
+// limit of burrow.HTTPHandler.
+const maxRequestBodyBytes = 32 * 1024 * 1024
+
+// detectMode returns the runtime mode. The Lambda Runtime API takes
+// precedence, since it is also set when the emulator runs this binary. Next
+// PORT selects HTTP, as set by Cloud Run and similar platforms, and then the
+// emulator is used if it is installed, as in the container image. Otherwise
+// HTTP is served on the default port.
+func detectMode() (string, error) {
+	mode := os.Getenv("BURROW_MODE")
+	switch mode {
+	case "", modeLambda, modeEmulator, modeHTTP:
+	default:
+		return "", fmt.Errorf("unknown BURROW_MODE: %s", mode)
+	}
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" && mode != modeHTTP {
+		return modeLambda, nil
+	}
+	if mode != "" {
+		return mode, nil
+	}
+	if os.Getenv("PORT") != "" {
+		return modeHTTP, nil
+	}
+	if _, err := os.Stat(getEmulatorPath()); err == nil {
+		return modeEmulator, nil
+	}
+	return modeHTTP, nil
+}
+
+// runEmulator replaces this process with the Lambda Runtime Interface
+// Emulator, which listens on port 8080 and runs this binary as the function.
+// Invoke it at /2015-03-31/functions/function/invocations, e.g. with a
+// burrow.LambdaBackend using "function" as the function name.
+func runEmulator() error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	emulator := getEmulatorPath()
+	return syscall.Exec(emulator, []string{emulator, self}, os.Environ())
+}
+
+// serveHTTP serves proxy requests until SIGINT or SIGTERM is received, then
+// waits for requests in flight to complete.
+func serveHTTP(h RequestHandler, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	h.Logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// ServeHTTP handles a proxy request sent directly over HTTP by converting it
+// to the Function URL event handled in Lambda, so both behave the same. It
+// doesn't use burrow.HTTPHandler, which would bypass the logging, tracing and
+// retirement in Handle, but it replies to invalid requests in the same way.
+func (h RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var response events.APIGatewayV2HTTPResponse
+	if r.Method != http.MethodPost {
+		response = NewGenericErrorResponse(http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	} else if body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodyBytes+1)); err != nil {
+		response = NewGenericErrorResponse(http.StatusRequestEntityTooLarge, fmt.Errorf("failed to read request body: %w", err))
+	} else if len(body) > maxRequestBodyBytes {
+		response = NewGenericErrorResponse(http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeded maximum size: %d", maxRequestBodyBytes))
+	} else {
+		sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			sourceIP = r.RemoteAddr
+		}
+		response, _ = h.Handle(r.Context(), events.APIGatewayV2HTTPRequest{
+			Body: string(body),
+			RequestContext: events.APIGatewayV2HTTPRequestContext{
+				HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+					Method:    r.Method,
+					Path:      r.URL.Path,
+					SourceIP:  sourceIP,
+					UserAgent: r.UserAgent(),
+				},
+			},
+		})
+	}
+	for key, value := range response.Headers {
+		w.Header().Set(key, value)
+	}
+	w.WriteHeader(response.StatusCode)
+	io.WriteString(w, response.Body)
+}
+
+// getProxyName returns BURROW_PROXY_NAME, or a name describing where the
+// proxy runs, e.g. "aws.lambda.us-east-1" or "gcp.run.burrow".
+func getProxyName(mode string) string {
+	if name := os.Getenv("BURROW_PROXY_NAME"); name != "" {
+		return name
+	}
+	if mode == modeHTTP {
+		if service := os.Getenv("K_SERVICE"); service != "" {
+			return "gcp.run." + service
+		}
+		hostname, _ := os.Hostname()
+		return "http." + hostname
+	}
+	return fmt.Sprintf("aws.lambda.%s", getRegion())
+}
+
+func getPort() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return port
+	}
+	return defaultPort
+}
+
+func getEmulatorPath() string {
+	if path := os.Getenv("BURROW_RIE_PATH"); path != "" {
+		return path
+	}
+	return defaultEmulatorPath
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/myzie/burrow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectMode(t *testing.T) {
+	emulator := filepath.Join(t.TempDir(), "aws-lambda-rie")
+	require.NoError(t, os.WriteFile(emulator, nil, 0o755))
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name       string
+		mode       string
+		runtimeAPI string
+		port       string
+		emulator   string
+		want       string
+		wantErr    string
+	}{
+		{name: "lambda runtime", runtimeAPI: "127.0.0.1:9001", port: "8080", emulator: emulator, want: modeLambda},
+		{name: "lambda runtime overrides rie", mode: modeEmulator, runtimeAPI: "127.0.0.1:9001", want: modeLambda},
+		{name: "http overrides lambda runtime", mode: modeHTTP, runtimeAPI: "127.0.0.1:9001", want: modeHTTP},
+		{name: "explicit mode", mode: modeEmulator, port: "8080", emulator: missing, want: modeEmulator},
+		{name: "port", port: "8080", emulator: emulator, want: modeHTTP},
+		{name: "emulator installed", emulator: emulator, want: modeEmulator},
+		{name: "nothing detected", emulator: missing, want: modeHTTP},
+		{name: "unknown mode", mode: "ecs", wantErr: "unknown BURROW_MODE: ecs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BURROW_MODE", tt.mode)
+			t.Setenv("AWS_LAMBDA_RUNTIME_API", tt.runtimeAPI)
+			t.Setenv("PORT", tt.port)
+			t.Setenv("BURROW_RIE_PATH", tt.emulator)
+			mode, err := detectMode()
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, mode)
+		})
+	}
+}
+
+func TestRequestHandler_ServeHTTP(t *testing.T) {
+	h := RequestHandler{
+		Burrow: func(ctx context.Context, req *burrow.Request) (*burrow.Response, error) {
+			return &burrow.Response{StatusCode: 200}, nil
+		},
+		Logger:    slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		ProxyName: "http.test",
+	}
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"url":"https://example.com"}`))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, string(body), `"proxy_name":"http.test"`)
+
+	resp, err = http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Post(server.URL, "application/json", strings.NewReader(strings.Repeat("x", maxRequestBodyBytes+1)))
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Contains(t, string(body), "request body exceeded maximum size")
+}
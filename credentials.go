@@ -0,0 +1,184 @@
+package burrow
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNoCredentials is returned when no AWS credentials could be found.
+var ErrNoCredentials = errors.New("no aws credentials found")
+
+// Credentials are AWS credentials used to sign requests.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// CredentialsProvider retrieves AWS credentials. Implementations must be safe
+// for concurrent use. To use credentials from the AWS SDK, wrap its provider
+// with a CredentialsProviderFunc.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// CredentialsProviderFunc adapts a function to the CredentialsProvider
+// interface.
+type CredentialsProviderFunc func(ctx context.Context) (Credentials, error)
+
+// Retrieve calls f(ctx).
+func (f CredentialsProviderFunc) Retrieve(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// StaticCredentials returns a provider that always returns the given
+// credentials.
+func StaticCredentials(accessKeyID, secretAccessKey, sessionToken string) CredentialsProvider {
+	creds := Credentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    sessionToken,
+	}
+	return CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		return creds, nil
+	})
+}
+
+// EnvCredentials returns a provider that reads credentials from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables.
+func EnvCredentials() CredentialsProvider {
+	return CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		creds := Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return Credentials{}, ErrNoCredentials
+		}
+		return creds, nil
+	})
+}
+
+// SharedCredentials returns a provider that reads credentials for a profile
+// from the shared credentials file (~/.aws/credentials) and then the shared
+// config file (~/.aws/config). An empty profile uses AWS_PROFILE, or
+// "default". The AWS_SHARED_CREDENTIALS_FILE and AWS_CONFIG_FILE environment
+// variables override the file locations. The files are read once.
+func SharedCredentials(profile string) CredentialsProvider {
+	var once sync.Once
+	var creds Credentials
+	var err error
+	return CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		once.Do(func() {
+			creds, err = loadSharedCredentials(profile)
+		})
+		return creds, err
+	})
+}
+
+// DefaultCredentials returns a provider that tries the environment and then
+// the shared credentials and config files, like the AWS SDKs.
+func DefaultCredentials() CredentialsProvider {
+	return ChainCredentials(EnvCredentials(), SharedCredentials(""))
+}
+
+// ChainCredentials returns a provider that returns the credentials from the
+// first provider that does not return ErrNoCredentials.
+func ChainCredentials(providers ...CredentialsProvider) CredentialsProvider {
+	return CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		for _, provider := range providers {
+			creds, err := provider.Retrieve(ctx)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			return creds, err
+		}
+		return Credentials{}, ErrNoCredentials
+	})
+}
+
+func loadSharedCredentials(profile string) (Credentials, error) {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	home, _ := os.UserHomeDir()
+	credentialsFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		credentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+	configFile := os.Getenv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		configFile = filepath.Join(home, ".aws", "config")
+	}
+	// Profiles in the config file are named "profile NAME", except default
+	configSection := "profile " + profile
+	if profile == "default" {
+		configSection = "default"
+	}
+	for _, source := range []struct{ path, section string }{
+		{credentialsFile, profile},
+		{configFile, configSection},
+	} {
+		values, err := readINISection(source.path, source.section)
+		if err != nil {
+			return Credentials{}, err
+		}
+		creds := Credentials{
+			AccessKeyID:     values["aws_access_key_id"],
+			SecretAccessKey: values["aws_secret_access_key"],
+			SessionToken:    values["aws_session_token"],
+		}
+		if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+			return creds, nil
+		}
+	}
+	return Credentials{}, ErrNoCredentials
+}
+
+// readINISection returns the keys in a section of an INI file. A missing file
+// is treated as empty.
+func readINISection(path, section string) (map[string]string, error) {
+	values := map[string]string{}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var current string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if current != section {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return values, nil
+}
@@ -0,0 +1,226 @@
+package burrow
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Diagnostics contains optional details about the upstream connection used by
+// the proxy to fulfill a request. It is only populated when requested.
+type Diagnostics struct {
+	RemoteAddr string      `json:"remote_addr,omitempty"`
+	Protocol   string      `json:"protocol,omitempty"`
+	ConnReused bool        `json:"conn_reused,omitempty"`
+	Timings    *Timings    `json:"timings,omitempty"`
+	TLS        *TLSDetails `json:"tls,omitempty"`
+}
+
+// Timings contains the duration of each phase of the upstream request, in
+// seconds. Phases that did not occur (e.g. DNS on a reused connection) are zero.
+type Timings struct {
+	DNS          float64 `json:"dns,omitempty"`
+	Connect      float64 `json:"connect,omitempty"`
+	TLSHandshake float64 `json:"tls_handshake,omitempty"`
+	// TimeToFirstByte is measured from the start of the request, so it
+	// includes the DNS, connect and TLS phases
+	TimeToFirstByte float64 `json:"time_to_first_byte,omitempty"`
+}
+
+// TLSDetails summarizes the TLS connection negotiated with the upstream server.
+type TLSDetails struct {
+	Version            uint16               `json:"version"`
+	VersionName        string               `json:"version_name,omitempty"`
+	CipherSuite        uint16               `json:"cipher_suite"`
+	CipherSuiteName    string               `json:"cipher_suite_name,omitempty"`
+	ServerName         string               `json:"server_name,omitempty"`
+	NegotiatedProtocol string               `json:"negotiated_protocol,omitempty"`
+	PeerCertificates   []CertificateSummary `json:"peer_certificates,omitempty"`
+}
+
+// CertificateSummary describes one certificate in the upstream peer chain.
+type CertificateSummary struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number,omitempty"`
+	DNSNames     []string  `json:"dns_names,omitempty"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+}
+
+// ConnectionState converts the TLS details into a tls.ConnectionState. Peer
+// certificates are not included since only their summaries are transmitted.
+func (d *TLSDetails) ConnectionState() *tls.ConnectionState {
+	return &tls.ConnectionState{
+		Version:            d.Version,
+		HandshakeComplete:  true,
+		CipherSuite:        d.CipherSuite,
+		ServerName:         d.ServerName,
+		NegotiatedProtocol: d.NegotiatedProtocol,
+	}
+}
+
+// tracePhase is the start and end time of one phase of an upstream request.
+type tracePhase struct {
+	start time.Time
+	end   time.Time
+}
+
+func (p tracePhase) complete() bool {
+	return !p.start.IsZero() && !p.end.IsZero()
+}
+
+func (p tracePhase) seconds() float64 {
+	if !p.complete() {
+		return 0
+	}
+	return p.end.Sub(p.start).Seconds()
+}
+
+// diagnosticsTrace records connection events for a single upstream request.
+// If the request is redirected, the values from the final connection are kept.
+type diagnosticsTrace struct {
+	mutex        sync.Mutex
+	start        time.Time
+	dns          tracePhase
+	connect      tracePhase
+	tlsHandshake tracePhase
+	firstByte    tracePhase
+	remoteAddr   string
+	connReused   bool
+}
+
+// withContext returns a context that reports events to the trace.
+func (d *diagnosticsTrace) withContext(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			// Each redirect gets a connection, which may be reused
+			d.start = time.Now()
+			d.dns = tracePhase{}
+			d.connect = tracePhase{}
+			d.tlsHandshake = tracePhase{}
+			d.firstByte = tracePhase{}
+			d.remoteAddr = ""
+			d.connReused = false
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			d.dns = tracePhase{start: time.Now()}
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			d.dns.end = time.Now()
+		},
+		ConnectStart: func(network, addr string) {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			d.connect = tracePhase{start: time.Now()}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			if err == nil {
+				d.connect.end = time.Now()
+			}
+		},
+		TLSHandshakeStart: func() {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			d.tlsHandshake = tracePhase{start: time.Now()}
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			d.tlsHandshake.end = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			d.remoteAddr = info.Conn.RemoteAddr().String()
+			d.connReused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			d.firstByte = tracePhase{start: d.start, end: time.Now()}
+		},
+	})
+}
+
+// diagnostics builds the Diagnostics for the given upstream response.
+func (d *diagnosticsTrace) diagnostics(resp *http.Response) *Diagnostics {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	diag := &Diagnostics{
+		RemoteAddr: d.remoteAddr,
+		Protocol:   resp.Proto,
+		ConnReused: d.connReused,
+		Timings: &Timings{
+			DNS:             d.dns.seconds(),
+			Connect:         d.connect.seconds(),
+			TLSHandshake:    d.tlsHandshake.seconds(),
+			TimeToFirstByte: d.firstByte.seconds(),
+		},
+	}
+	if resp.TLS != nil {
+		diag.TLS = newTLSDetails(resp.TLS)
+	}
+	return diag
+}
+
+// phases returns the completed phases of the request, named for use as spans.
+func (d *diagnosticsTrace) phases() []namedPhase {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var phases []namedPhase
+	for _, p := range []namedPhase{
+		{"dns", d.dns},
+		{"connect", d.connect},
+		{"tls_handshake", d.tlsHandshake},
+		{"time_to_first_byte", d.firstByte},
+	} {
+		if p.phase.complete() {
+			phases = append(phases, p)
+		}
+	}
+	return phases
+}
+
+type namedPhase struct {
+	name  string
+	phase tracePhase
+}
+
+func newTLSDetails(state *tls.ConnectionState) *TLSDetails {
+	details := &TLSDetails{
+		Version:            state.Version,
+		VersionName:        tls.VersionName(state.Version),
+		CipherSuite:        state.CipherSuite,
+		CipherSuiteName:    tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+	}
+	for _, cert := range state.PeerCertificates {
+		details.PeerCertificates = append(details.PeerCertificates, newCertificateSummary(cert))
+	}
+	return details
+}
+
+func newCertificateSummary(cert *x509.Certificate) CertificateSummary {
+	return CertificateSummary{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SerialNumber: cert.SerialNumber.String(),
+		DNSNames:     cert.DNSNames,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+	}
+}
@@ -0,0 +1,86 @@
+package burrow
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHandler_Diagnostics(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	handler := GetHandler(upstream.Client())
+
+	resp, err := handler(context.Background(), &Request{URL: upstream.URL})
+	require.NoError(t, err)
+	assert.Nil(t, resp.Diagnostics)
+
+	resp, err = handler(context.Background(), &Request{URL: upstream.URL, Diagnostics: true})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Diagnostics)
+	assert.Equal(t, upstream.Listener.Addr().String(), resp.Diagnostics.RemoteAddr)
+	assert.Equal(t, "HTTP/1.1", resp.Diagnostics.Protocol)
+	require.NotNil(t, resp.Diagnostics.Timings)
+	// Time to first byte is measured from the start of the request
+	timings := resp.Diagnostics.Timings
+	assert.GreaterOrEqual(t, timings.TimeToFirstByte, timings.DNS+timings.Connect+timings.TLSHandshake)
+	require.NotNil(t, resp.Diagnostics.TLS)
+	assert.NotZero(t, resp.Diagnostics.TLS.Version)
+	assert.NotEmpty(t, resp.Diagnostics.TLS.CipherSuiteName)
+	require.Len(t, resp.Diagnostics.TLS.PeerCertificates, 1)
+	assert.Contains(t, resp.Diagnostics.TLS.PeerCertificates[0].DNSNames, "example.com")
+}
+
+func TestGetHandler_DiagnosticsRedirect(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	// The redirect reuses the connection, so it has no connection timings
+	resp, err := GetHandler(upstream.Client())(context.Background(), &Request{URL: upstream.URL + "/start", Diagnostics: true})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Diagnostics)
+	assert.True(t, resp.Diagnostics.ConnReused)
+	assert.Equal(t, upstream.Listener.Addr().String(), resp.Diagnostics.RemoteAddr)
+	timings := resp.Diagnostics.Timings
+	assert.Zero(t, timings.DNS)
+	assert.Zero(t, timings.Connect)
+	assert.Zero(t, timings.TLSHandshake)
+	assert.NotZero(t, timings.TimeToFirstByte)
+}
+
+func TestDeserializeResponse_Diagnostics(t *testing.T) {
+	resp, err := DeserializeResponse(&Response{
+		StatusCode: 200,
+		Body:       "aGVsbG8=",
+		Diagnostics: &Diagnostics{
+			Protocol: "HTTP/2.0",
+			TLS: &TLSDetails{
+				Version:            tls.VersionTLS13,
+				CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+				NegotiatedProtocol: "h2",
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, 0, resp.ProtoMinor)
+	require.NotNil(t, resp.TLS)
+	assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+	assert.Equal(t, "h2", resp.TLS.NegotiatedProtocol)
+}
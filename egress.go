@@ -0,0 +1,306 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEgressEchoURL is the echo service used to discover the egress IP of
+// a proxy. It returns the caller's IP address as plain text.
+const DefaultEgressEchoURL = "https://checkip.amazonaws.com"
+
+var (
+	defaultEgressTimeout    = 3 * time.Second
+	defaultEgressRetryAfter = time.Minute
+)
+
+// EgressIPResolver discovers the public IP address that proxied requests
+// leave from by calling an echo service. The address is cached until the
+// execution environment changes or the optional maximum age is reached.
+type EgressIPResolver struct {
+	echoURL       string
+	client        *http.Client
+	environmentID func() string
+	maxAge        time.Duration
+	timeout       time.Duration
+	retryAfter    time.Duration
+	mutex         sync.Mutex
+	ip            string
+	err           error
+	environment   string
+	checked       time.Time
+	pending       *egressLookup
+}
+
+// egressLookup is a discovery shared by the callers that are waiting for it.
+type egressLookup struct {
+	environment string
+	done        chan struct{}
+	ip          string
+	err         error
+}
+
+// NewEgressIPResolver creates an EgressIPResolver that uses the given echo
+// URL, or DefaultEgressEchoURL if it is empty. The echo service must return
+// the caller's IP address as plain text, or as JSON with an "ip" field.
+func NewEgressIPResolver(echoURL string) *EgressIPResolver {
+	if echoURL == "" {
+		echoURL = DefaultEgressEchoURL
+	}
+	return &EgressIPResolver{
+		echoURL:       echoURL,
+		client:        &http.Client{Transport: defaultTransport, Timeout: defaultEgressTimeout},
+		environmentID: EnvironmentID,
+		timeout:       defaultEgressTimeout,
+		retryAfter:    defaultEgressRetryAfter,
+	}
+}
+
+// WithClient sets the HTTP client used to call the echo service.
+func (r *EgressIPResolver) WithClient(client *http.Client) *EgressIPResolver {
+	r.client = client
+	return r
+}
+
+// WithEnvironmentID sets the function used to detect that the execution
+// environment has changed, which causes the egress IP to be rediscovered.
+func (r *EgressIPResolver) WithEnvironmentID(fn func() string) *EgressIPResolver {
+	r.environmentID = fn
+	return r
+}
+
+// WithMaxAge sets how long a discovered IP is cached. By default it is cached
+// for the lifetime of the execution environment.
+func (r *EgressIPResolver) WithMaxAge(maxAge time.Duration) *EgressIPResolver {
+	r.maxAge = maxAge
+	return r
+}
+
+// Resolve returns the egress IP, discovering it if it is not cached. After a
+// failed lookup the error is returned without retrying for a short time, so
+// that an unreachable echo service does not slow down every request.
+//
+// Concurrent callers share one discovery, which runs without the lock held
+// and is not cancelled with ctx, so a cancelled caller returns ctx.Err()
+// without the failure being cached for the others.
+func (r *EgressIPResolver) Resolve(ctx context.Context) (string, error) {
+	r.mutex.Lock()
+	environment := r.environmentID()
+	if environment == r.environment && !r.checked.IsZero() {
+		age := time.Since(r.checked)
+		if r.err != nil && age < r.retryAfter {
+			r.mutex.Unlock()
+			return "", r.err
+		}
+		if r.err == nil && (r.maxAge <= 0 || age < r.maxAge) {
+			r.mutex.Unlock()
+			return r.ip, nil
+		}
+	}
+	lookup := r.pending
+	if lookup == nil || lookup.environment != environment {
+		lookup = &egressLookup{environment: environment, done: make(chan struct{})}
+		r.pending = lookup
+		go r.discover(context.WithoutCancel(ctx), lookup)
+	}
+	r.mutex.Unlock()
+
+	select {
+	case <-lookup.done:
+		return lookup.ip, lookup.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// discover runs a lookup with its own timeout and caches the result.
+func (r *EgressIPResolver) discover(ctx context.Context, lookup *egressLookup) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	lookup.ip, lookup.err = r.lookup(ctx)
+
+	r.mutex.Lock()
+	if r.pending == lookup {
+		r.pending = nil
+		r.ip, r.err = lookup.ip, lookup.err
+		r.environment = lookup.environment
+		r.checked = time.Now()
+	}
+	r.mutex.Unlock()
+	close(lookup.done)
+}
+
+func (r *EgressIPResolver) lookup(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", r.echoURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create egress ip request: %w", err)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to discover egress ip: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", fmt.Errorf("failed to read egress ip response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("egress ip echo service returned status %d", resp.StatusCode)
+	}
+	ip := parseEchoIP(body)
+	if ip == "" {
+		return "", fmt.Errorf("egress ip echo service returned an invalid response")
+	}
+	return ip, nil
+}
+
+// parseEchoIP returns the IP address in an echo service response.
+func parseEchoIP(body []byte) string {
+	value := strings.TrimSpace(string(body))
+	var echo struct {
+		IP string `json:"ip"`
+	}
+	if err := json.Unmarshal(body, &echo); err == nil {
+		value = echo.IP
+	}
+	if net.ParseIP(value) == nil {
+		return ""
+	}
+	return value
+}
+
+// WithEgressIP returns a Handler that reports the egress IP of the execution
+// environment in each successful response. Discovery failures are not
+// reported to the caller; the egress IP is simply omitted.
+func (h Handler) WithEgressIP(resolver *EgressIPResolver) Handler {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		resp, err := h(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp.EgressIP, _ = resolver.Resolve(ctx)
+		return resp, nil
+	}
+}
+
+// EgressTracker records the distinct egress IPs seen for each region, to
+// measure how diverse the addresses used by the proxies are.
+type EgressTracker struct {
+	mutex        sync.Mutex
+	regions      map[string]*EgressRegionStats
+	environments map[string]map[string]bool
+}
+
+// EgressRegionStats describes the egress IPs seen for one region.
+type EgressRegionStats struct {
+	Region string
+	// Requests is the number of responses that reported an egress IP or an
+	// execution environment
+	Requests int
+	// IPs is sorted by the time each IP was first seen
+	IPs []*EgressIPStats
+	// Environments is the number of distinct execution environments seen
+	Environments int
+}
+
+// EgressIPStats describes how often an egress IP was seen.
+type EgressIPStats struct {
+	IP        string
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// NewEgressTracker creates an empty EgressTracker.
+func NewEgressTracker() *EgressTracker {
+	return &EgressTracker{
+		regions:      map[string]*EgressRegionStats{},
+		environments: map[string]map[string]bool{},
+	}
+}
+
+// Observe records the egress IP and execution environment reported in the
+// metadata of a response. Responses without either are ignored.
+func (t *EgressTracker) Observe(md *Metadata) {
+	if md == nil || (md.EgressIP == "" && md.EnvironmentID == "") {
+		return
+	}
+	region := regionOf(md.ProxyURL, md.ProxyName)
+	if region == "" {
+		region = md.ProxyName
+	}
+	if region == "" {
+		region = md.ProxyURL
+	}
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stats, ok := t.regions[region]
+	if !ok {
+		stats = &EgressRegionStats{Region: region}
+		t.regions[region] = stats
+	}
+	stats.Requests++
+	if md.EnvironmentID != "" {
+		if t.environments[region] == nil {
+			t.environments[region] = map[string]bool{}
+		}
+		t.environments[region][md.EnvironmentID] = true
+		stats.Environments = len(t.environments[region])
+	}
+	if md.EgressIP == "" {
+		return
+	}
+	for _, ip := range stats.IPs {
+		if ip.IP == md.EgressIP {
+			ip.Count++
+			ip.LastSeen = now
+			return
+		}
+	}
+	stats.IPs = append(stats.IPs, &EgressIPStats{
+		IP:        md.EgressIP,
+		Count:     1,
+		FirstSeen: now,
+		LastSeen:  now,
+	})
+}
+
+// Regions returns a copy of the stats for each region, sorted by region.
+func (t *EgressTracker) Regions() []EgressRegionStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	regions := make([]EgressRegionStats, 0, len(t.regions))
+	for _, stats := range t.regions {
+		region := EgressRegionStats{
+			Region:       stats.Region,
+			Requests:     stats.Requests,
+			Environments: stats.Environments,
+		}
+		for _, ip := range stats.IPs {
+			copied := *ip
+			region.IPs = append(region.IPs, &copied)
+		}
+		regions = append(regions, region)
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Region < regions[j].Region })
+	return regions
+}
+
+// DistinctIPs returns the number of distinct egress IPs seen for a region.
+func (t *EgressTracker) DistinctIPs(region string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if stats, ok := t.regions[region]; ok {
+		return len(stats.IPs)
+	}
+	return 0
+}
@@ -0,0 +1,151 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEgressIPResolver(t *testing.T) {
+	var lookups int32
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&lookups, 1) == 1 {
+			w.Write([]byte("203.0.113.7\n"))
+			return
+		}
+		w.Write([]byte(`{"ip":"203.0.113.8"}`))
+	}))
+	defer echo.Close()
+
+	environment := "env-1"
+	resolver := NewEgressIPResolver(echo.URL).
+		WithClient(echo.Client()).
+		WithEnvironmentID(func() string { return environment })
+
+	ip, err := resolver.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", ip)
+
+	// Cached for the same execution environment
+	ip, err = resolver.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", ip)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+
+	// Rediscovered when the execution environment changes
+	environment = "env-2"
+	ip, err = resolver.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.8", ip)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&lookups))
+}
+
+func TestEgressIPResolver_Failure(t *testing.T) {
+	var lookups int32
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		w.Write([]byte("not an ip"))
+	}))
+	defer echo.Close()
+
+	resolver := NewEgressIPResolver(echo.URL).WithClient(echo.Client())
+	_, err := resolver.Resolve(context.Background())
+	require.EqualError(t, err, "egress ip echo service returned an invalid response")
+
+	// Failures are not retried immediately
+	_, err = resolver.Resolve(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+}
+
+func TestEgressIPResolver_CancelledCaller(t *testing.T) {
+	var lookups int32
+	release := make(chan struct{})
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		<-release
+		w.Write([]byte("203.0.113.9"))
+	}))
+	defer echo.Close()
+
+	resolver := NewEgressIPResolver(echo.URL).WithClient(echo.Client())
+
+	// A caller that gives up does not cancel or fail the shared lookup
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := resolver.Resolve(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// Concurrent callers wait for the same lookup
+	var wg sync.WaitGroup
+	ips := make([]string, 5)
+	for i := range ips {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ips[i], _ = resolver.Resolve(context.Background())
+		}(i)
+	}
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []string{"203.0.113.9", "203.0.113.9", "203.0.113.9", "203.0.113.9", "203.0.113.9"}, ips)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+}
+
+func TestHandler_WithEgressIP(t *testing.T) {
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("198.51.100.4"))
+	}))
+	defer echo.Close()
+
+	handler := Handler(func(ctx context.Context, req *Request) (*Response, error) {
+		return &Response{StatusCode: 200}, nil
+	}).WithEgressIP(NewEgressIPResolver(echo.URL).WithClient(echo.Client()))
+
+	resp, err := handler(context.Background(), &Request{URL: "https://example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.4", resp.EgressIP)
+}
+
+func TestEgressTracker(t *testing.T) {
+	var n int32
+	ips := []string{"192.0.2.1", "192.0.2.2", "192.0.2.1"}
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := atomic.AddInt32(&n, 1) - 1
+		json.NewEncoder(w).Encode(Response{
+			StatusCode: 200,
+			ProxyName:  "aws.lambda.us-west-2",
+			EgressIP:   ips[i],
+		})
+	}))
+	defer mockProxy.Close()
+
+	tracker := NewEgressTracker()
+	client := NewClient(WithProxyURL(mockProxy.URL), WithEgressTracker(tracker), WithMetadataHeaders(true))
+	client.Timeout = 10 * time.Second
+
+	for range ips {
+		resp, err := client.Get("https://example.com")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, ResponseMetadata(resp).EgressIP, resp.Header.Get(HeaderEgressIP))
+	}
+
+	assert.Equal(t, 2, tracker.DistinctIPs("us-west-2"))
+	regions := tracker.Regions()
+	require.Len(t, regions, 1)
+	assert.Equal(t, "us-west-2", regions[0].Region)
+	assert.Equal(t, 3, regions[0].Requests)
+	require.Len(t, regions[0].IPs, 2)
+	assert.Equal(t, "192.0.2.1", regions[0].IPs[0].IP)
+	assert.Equal(t, 2, regions[0].IPs[0].Count)
+	assert.Equal(t, "192.0.2.2", regions[0].IPs[1].IP)
+}
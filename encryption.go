@@ -0,0 +1,278 @@
+package burrow
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EncryptionKeySize is the size of the keys used to encrypt envelopes, which
+// are encrypted with AES-256-GCM.
+const EncryptionKeySize = 32
+
+// ErrUnencryptedPayload is returned when a proxy that requires encryption
+// receives a payload that is not an Envelope.
+var ErrUnencryptedPayload = errors.New("payload is not encrypted")
+
+// Envelope is an encrypted proxy payload or reply. When encryption is enabled
+// the URL, headers and bodies of requests and responses are only visible to
+// the client and the handler, not to the platform or anything that logs the
+// function's payloads.
+type Envelope struct {
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// sealedRequest is the plaintext of an encrypted request. The time it was sent
+// limits how long a captured request can be replayed.
+type sealedRequest struct {
+	SentAt  int64           `json:"sent_at"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// maxRequestAge is how far the time a request was sent may be from the time
+// it is opened, allowing for clock skew.
+var maxRequestAge = 5 * time.Minute
+
+// sealedReply is the plaintext of an encrypted reply. The status code is
+// encrypted with the body so the reply is always delivered with status 200.
+type sealedReply struct {
+	StatusCode int             `json:"status_code"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// Additional data that binds an envelope to its direction, so that a request
+// can't be replayed as a reply or vice versa. A reply is also bound to the
+// nonce of its request, so that it can't be replayed for another request.
+const (
+	requestEnvelopeLabel = "burrow request"
+	replyEnvelopeLabel   = "burrow reply"
+)
+
+// IsEnvelopePayload returns true if the JSON payload is an Envelope.
+func IsEnvelopePayload(payload []byte) bool {
+	var probe struct {
+		Ciphertext json.RawMessage `json:"ciphertext"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return false
+	}
+	return len(probe.Ciphertext) > 0
+}
+
+// Keyring holds the keys shared by clients and handlers to encrypt
+// envelopes. Each key has an ID that is sent in the clear with the envelope.
+// Requests are encrypted with the primary key and replies with the key of the
+// request. To rotate keys, add the new key to every keyring, then make it
+// primary on clients, then remove the old key.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring with the given keys, which must be
+// EncryptionKeySize bytes, using primaryID to encrypt requests.
+func NewKeyring(primaryID string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{primary: primaryID, keys: map[string]cipher.AEAD{}}
+	for id, key := range keys {
+		if id == "" {
+			return nil, errors.New("encryption key id is required")
+		}
+		if len(key) != EncryptionKeySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes", id, EncryptionKeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if k.keys[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := k.keys[primaryID]; !ok {
+		return nil, fmt.Errorf("primary encryption key %q not found", primaryID)
+	}
+	return k, nil
+}
+
+// ParseKeyring creates a keyring from a comma-separated list of keys in the
+// form "id:base64key", as in the BURROW_ENCRYPTION_KEYS environment variable.
+// The first key is the primary key. Generate a key with e.g.
+// "openssl rand -base64 32".
+func ParseKeyring(s string) (*Keyring, error) {
+	var primary string
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, errors.New("encryption keys must be in the form id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %w", id, err)
+		}
+		if primary == "" {
+			primary = id
+		}
+		keys[id] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+	return NewKeyring(primary, keys)
+}
+
+// SealRequest encrypts a JSON Request or BatchRequest with the primary key
+// and returns the JSON Envelope. The Envelope is also returned to open the
+// reply with.
+func (k *Keyring) SealRequest(payload []byte) ([]byte, *Envelope, error) {
+	plaintext, err := json.Marshal(sealedRequest{SentAt: time.Now().Unix(), Payload: payload})
+	if err != nil {
+		return nil, nil, err
+	}
+	envelope, err := k.seal(k.primary, []byte(requestEnvelopeLabel), plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := json.Marshal(envelope)
+	return data, envelope, err
+}
+
+// OpenRequest decrypts a JSON Envelope containing a request and returns the
+// payload and the Envelope, which identifies the key and nonce to seal the
+// reply for. Requests sent too long ago are rejected.
+func (k *Keyring) OpenRequest(data []byte) ([]byte, *Envelope, error) {
+	plaintext, envelope, err := k.open([]byte(requestEnvelopeLabel), data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var request sealedRequest
+	if err := json.Unmarshal(plaintext, &request); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal request: %w", err)
+	}
+	age := time.Since(time.Unix(request.SentAt, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return nil, nil, errors.New("envelope expired")
+	}
+	return request.Payload, envelope, nil
+}
+
+// SealReply encrypts a reply to a request opened with OpenRequest, with the
+// key of the request. The status code is encrypted along with the JSON body.
+func (k *Keyring) SealReply(request *Envelope, statusCode int, body []byte) ([]byte, error) {
+	if !json.Valid(body) {
+		// Error replies are JSON, but don't fail if one isn't
+		body, _ = json.Marshal(string(body))
+	}
+	plaintext, err := json.Marshal(sealedReply{StatusCode: statusCode, Body: body})
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := k.seal(request.KeyID, replyAdditionalData(request), plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope)
+}
+
+// OpenReply decrypts a JSON Envelope containing the reply to a request sealed
+// with SealRequest and returns its status code and body.
+func (k *Keyring) OpenReply(request *Envelope, data []byte) (int, []byte, error) {
+	plaintext, _, err := k.open(replyAdditionalData(request), data)
+	if err != nil {
+		return 0, nil, err
+	}
+	var reply sealedReply
+	if err := json.Unmarshal(plaintext, &reply); err != nil {
+		return 0, nil, fmt.Errorf("failed to unmarshal reply: %w", err)
+	}
+	return reply.StatusCode, reply.Body, nil
+}
+
+func replyAdditionalData(request *Envelope) []byte {
+	return append([]byte(replyEnvelopeLabel), request.Nonce...)
+}
+
+func (k *Keyring) seal(keyID string, additionalData, plaintext []byte) (*Envelope, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key: %s", keyID)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return &Envelope{
+		KeyID:      keyID,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, additionalData),
+	}, nil
+}
+
+func (k *Keyring) open(additionalData, data []byte) ([]byte, *Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil || len(envelope.Ciphertext) == 0 {
+		return nil, nil, ErrUnencryptedPayload
+	}
+	aead, ok := k.keys[envelope.KeyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown encryption key: %s", envelope.KeyID)
+	}
+	if len(envelope.Nonce) != aead.NonceSize() {
+		return nil, nil, errors.New("invalid envelope nonce")
+	}
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, additionalData)
+	if err != nil {
+		return nil, nil, errors.New("failed to decrypt envelope")
+	}
+	return plaintext, &envelope, nil
+}
+
+// encryptedBackend encrypts payloads sent through another backend.
+type encryptedBackend struct {
+	backend Backend
+	keyring *Keyring
+}
+
+// NewEncryptedBackend returns a backend that encrypts payloads sent to the
+// function through backend and decrypts its replies. The function must be
+// configured with the same keys.
+func NewEncryptedBackend(backend Backend, keyring *Keyring) Backend {
+	return &encryptedBackend{backend: backend, keyring: keyring}
+}
+
+// URL returns the URL of the underlying backend
+func (b *encryptedBackend) URL() string {
+	return b.backend.URL()
+}
+
+// Invoke implements the Backend interface
+func (b *encryptedBackend) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	data, request, err := b.keyring.SealRequest(payload)
+	if err != nil {
+		return nil, err
+	}
+	// Errors before the payload is decrypted, e.g. authorization errors from
+	// the platform, are not encrypted
+	reply, err := b.backend.Invoke(ctx, data)
+	if err != nil {
+		return reply, err
+	}
+	statusCode, body, err := b.keyring.OpenReply(request, reply)
+	if err != nil {
+		return nil, &protocolError{fmt.Errorf("failed to open reply: %w", err)}
+	}
+	return proxyReply(statusCode, body)
+}
@@ -0,0 +1,220 @@
+package burrow
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{1}, EncryptionKeySize)
+	testKey2 = bytes.Repeat([]byte{2}, EncryptionKeySize)
+)
+
+func TestKeyring(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": testKey1, "k2": testKey2})
+	require.NoError(t, err)
+
+	envelope, sealed, err := keyring.SealRequest([]byte(`{"url":"https://example.com/secret"}`))
+	require.NoError(t, err)
+	assert.True(t, IsEnvelopePayload(envelope))
+	assert.NotContains(t, string(envelope), "secret")
+
+	payload, request, err := keyring.OpenRequest(envelope)
+	require.NoError(t, err)
+	assert.Equal(t, `{"url":"https://example.com/secret"}`, string(payload))
+	assert.Equal(t, "k1", request.KeyID)
+	assert.Equal(t, sealed, request)
+
+	// A request can't be replayed as a reply
+	_, _, err = keyring.OpenReply(request, envelope)
+	assert.EqualError(t, err, "failed to decrypt envelope")
+
+	request.KeyID = "k2"
+	reply, err := keyring.SealReply(request, 400, []byte(`{"message":"bad request","type":1}`))
+	require.NoError(t, err)
+	statusCode, body, err := keyring.OpenReply(request, reply)
+	require.NoError(t, err)
+	assert.Equal(t, 400, statusCode)
+	assert.Equal(t, `{"message":"bad request","type":1}`, string(body))
+
+	// A reply can't be replayed for another request
+	_, other, err := keyring.SealRequest([]byte(`{"url":"https://example.com"}`))
+	require.NoError(t, err)
+	_, _, err = keyring.OpenReply(other, reply)
+	assert.EqualError(t, err, "failed to decrypt envelope")
+
+	otherKeys, err := NewKeyring("k1", map[string][]byte{"k1": testKey2})
+	require.NoError(t, err)
+	_, _, err = otherKeys.OpenRequest(envelope)
+	assert.EqualError(t, err, "failed to decrypt envelope")
+	_, _, err = otherKeys.OpenReply(request, reply)
+	assert.EqualError(t, err, "unknown encryption key: k2")
+	_, _, err = otherKeys.OpenRequest([]byte(`{"url":"https://example.com"}`))
+	assert.ErrorIs(t, err, ErrUnencryptedPayload)
+}
+
+func TestKeyring_ExpiredRequest(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": testKey1})
+	require.NoError(t, err)
+	for _, sentAt := range []time.Time{time.Now().Add(-time.Hour), time.Now().Add(time.Hour)} {
+		plaintext, err := json.Marshal(sealedRequest{SentAt: sentAt.Unix(), Payload: []byte(`{}`)})
+		require.NoError(t, err)
+		envelope, err := keyring.seal("k1", []byte(requestEnvelopeLabel), plaintext)
+		require.NoError(t, err)
+		data, err := json.Marshal(envelope)
+		require.NoError(t, err)
+		_, _, err = keyring.OpenRequest(data)
+		assert.EqualError(t, err, "envelope expired")
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	keys := "k2:" + base64.StdEncoding.EncodeToString(testKey2) + ", k1:" + base64.StdEncoding.EncodeToString(testKey1)
+	keyring, err := ParseKeyring(keys)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", keyring.primary)
+	assert.Len(t, keyring.keys, 2)
+
+	_, err = ParseKeyring("k1:" + base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.EqualError(t, err, `encryption key "k1" must be 32 bytes`)
+	_, err = ParseKeyring(base64.StdEncoding.EncodeToString(testKey1))
+	assert.EqualError(t, err, "encryption keys must be in the form id:base64key")
+	_, err = ParseKeyring("")
+	assert.EqualError(t, err, "no encryption keys")
+}
+
+func TestEncryption(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("token " + r.Header.Get("Authorization")))
+	}))
+	defer origin.Close()
+
+	serverKeys, err := NewKeyring("k1", map[string][]byte{"k1": testKey1, "k2": testKey2})
+	require.NoError(t, err)
+	handler := NewHTTPHandler(GetHandler()).WithEncryption(serverKeys)
+
+	// Record what the platform sees
+	var seen bytes.Buffer
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		seen.Write(payload)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", bytes.NewReader(payload)))
+		seen.Write(rec.Body.Bytes())
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	defer function.Close()
+
+	// The client has started rotating to k2
+	clientKeys, err := NewKeyring("k2", map[string][]byte{"k1": testKey1, "k2": testKey2})
+	require.NoError(t, err)
+	client := NewClient(WithProxyURL(function.URL), WithEncryption(clientKeys))
+	client.Timeout = 10 * time.Second
+
+	req, err := http.NewRequest("GET", origin.URL+"/private", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "token Bearer s3cret", string(body))
+	assert.NotContains(t, seen.String(), "s3cret")
+	assert.NotContains(t, seen.String(), "/private")
+	assert.NotContains(t, seen.String(), base64.StdEncoding.EncodeToString(body))
+
+	// Error replies are encrypted too
+	seen.Reset()
+	_, err = client.Get("ftp://example.com/private")
+	assert.ErrorContains(t, err, `proxy error [0] failed to execute http request: Get "ftp://example.com/private"`)
+	assert.NotContains(t, seen.String(), "/private")
+
+	// Proxies without the key reject the request
+	oldKeys, err := NewKeyring("k1", map[string][]byte{"k1": testKey1})
+	require.NoError(t, err)
+	handler.WithEncryption(oldKeys)
+	_, err = client.Get(origin.URL)
+	assert.ErrorContains(t, err, "proxy error [0] unknown encryption key: k2")
+
+	// And so do proxies that require encryption
+	_, err = NewClient(WithProxyURL(function.URL)).Get(origin.URL)
+	assert.ErrorContains(t, err, "proxy error [0] payload is not encrypted")
+}
+
+func TestEncryption_Batch(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+	}))
+	defer origin.Close()
+
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": testKey1})
+	require.NoError(t, err)
+	function := httptest.NewServer(NewHTTPHandler(GetHandler()).WithEncryption(keyring))
+	defer function.Close()
+
+	transport := NewBatchTransport(NewTransport(function.URL, "POST").WithEncryption(keyring)).
+		WithWindow(50 * time.Millisecond)
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	results := make(chan string, 2)
+	for _, path := range []string{"a", "b"} {
+		go func(path string) {
+			resp, err := client.Get(origin.URL + "/" + path)
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			results <- string(body)
+		}(path)
+	}
+	got := []string{<-results, <-results}
+	assert.ElementsMatch(t, []string{"a", "b"}, got)
+}
+
+func TestEncryption_SigV4(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": testKey1})
+	require.NoError(t, err)
+	handler := NewHTTPHandler(func(ctx context.Context, req *Request) (*Response, error) {
+		return &Response{StatusCode: 204}, nil
+	}).WithEncryption(keyring)
+	secrets := map[string]string{"AKID": "SECRET"}
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if err := verifySigV4(r, body, secrets); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	}))
+	defer function.Close()
+
+	// Signing applies whether it is configured before or after encryption
+	credentials := StaticCredentials("AKID", "SECRET", "")
+	for _, transport := range []*Transport{
+		NewTransport(function.URL, "POST").WithSigV4("us-east-1", credentials).WithEncryption(keyring),
+		NewTransport(function.URL, "POST").WithEncryption(keyring).WithSigV4("us-east-1", credentials),
+	} {
+		resp, err := (&http.Client{Transport: transport}).Get("https://example.com")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 204, resp.StatusCode)
+	}
+}
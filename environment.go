@@ -0,0 +1,34 @@
+package burrow
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// EnvironmentID returns an identifier for the current execution environment.
+// In AWS Lambda this is the log stream name, which is unique to each
+// execution environment. Elsewhere the hostname and process ID are used.
+func EnvironmentID() string {
+	if stream := os.Getenv("AWS_LAMBDA_LOG_STREAM_NAME"); stream != "" {
+		return stream
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid())
+}
+
+type retireKey struct{}
+
+// RetireEnvironment returns a context that asks the proxy to retire its
+// execution environment after responding to a request made with it. Later
+// requests to the same proxy are then served by a new execution environment,
+// which in AWS Lambda usually has a different egress IP. Retiring an
+// environment means the next request pays for a cold start.
+func RetireEnvironment(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retireKey{}, true)
+}
+
+func retireFromContext(ctx context.Context) bool {
+	retire, _ := ctx.Value(retireKey{}).(bool)
+	return retire
+}
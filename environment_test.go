@@ -0,0 +1,95 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentID(t *testing.T) {
+	t.Setenv("AWS_LAMBDA_LOG_STREAM_NAME", "2024/01/01/[$LATEST]abc123")
+	assert.Equal(t, "2024/01/01/[$LATEST]abc123", EnvironmentID())
+
+	t.Setenv("AWS_LAMBDA_LOG_STREAM_NAME", "")
+	assert.NotEmpty(t, EnvironmentID())
+}
+
+func TestRetireEnvironment(t *testing.T) {
+	var mutex sync.Mutex
+	var retired []bool
+	environment := 1
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var serReq Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&serReq))
+		mutex.Lock()
+		defer mutex.Unlock()
+		retired = append(retired, serReq.Retire)
+		json.NewEncoder(w).Encode(Response{
+			StatusCode:    200,
+			ProxyName:     "aws.lambda.eu-west-1",
+			EnvironmentID: string(rune('a' + environment)),
+		})
+		// A retired environment is replaced by a new one
+		if serReq.Retire {
+			environment++
+		}
+	}))
+	defer mockProxy.Close()
+
+	tracker := NewEgressTracker()
+	client := NewClient(WithProxyURL(mockProxy.URL), WithRetireEvery(2), WithEgressTracker(tracker))
+	client.Timeout = 10 * time.Second
+
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get("https://example.com")
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	req, err := http.NewRequestWithContext(RetireEnvironment(context.Background()), "GET", "https://example.com", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, []bool{false, true, false, true, true}, retired)
+	regions := tracker.Regions()
+	require.Len(t, regions, 1)
+	assert.Equal(t, 5, regions[0].Requests)
+	assert.Equal(t, 3, regions[0].Environments)
+	assert.Empty(t, regions[0].IPs)
+}
+
+func TestRetireEvery_IgnoresRetries(t *testing.T) {
+	var mutex sync.Mutex
+	var retired []bool
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var serReq Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&serReq))
+		mutex.Lock()
+		defer mutex.Unlock()
+		retired = append(retired, serReq.Retire)
+		status := 200
+		// The first request is retried once
+		if len(retired) == 1 {
+			status = http.StatusServiceUnavailable
+		}
+		json.NewEncoder(w).Encode(Response{StatusCode: status})
+	}))
+	defer mockProxy.Close()
+
+	client := NewClient(WithProxyURL(mockProxy.URL), WithRetries(1), WithRetireEvery(2))
+	client.Timeout = 10 * time.Second
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get("https://example.com")
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, []bool{false, false, true, false, true}, retired)
+}
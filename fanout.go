@@ -0,0 +1,272 @@
+package burrow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FanOutClient sends the same request through many proxies concurrently,
+// e.g. to compare how a service responds in each region.
+type FanOutClient struct {
+	proxyURLs  map[string]string
+	transports map[string]http.RoundTripper
+}
+
+// FanOutResult is the outcome of a request sent through one proxy.
+type FanOutResult struct {
+	Name       string
+	ProxyURL   string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Metadata   *Metadata
+	Err        error
+	// Duration is the total time taken by the client
+	Duration time.Duration
+}
+
+// NewFanOutClient creates a FanOutClient for the named proxies, e.g. the
+// region to Function URL map in function_urls.json. The options are applied
+// to the transport for each proxy.
+func NewFanOutClient(proxies map[string]string, opts ...ClientOption) *FanOutClient {
+	transports := make(map[string]http.RoundTripper, len(proxies))
+	for name, proxyURL := range proxies {
+		transportOpts := append(append([]ClientOption{}, opts...), WithProxyURL(proxyURL))
+		transports[name] = NewTransportWithOptions(transportOpts...)
+	}
+	return &FanOutClient{proxyURLs: proxies, transports: transports}
+}
+
+// Names returns the sorted names of the proxies.
+func (f *FanOutClient) Names() []string {
+	return sortedNames(f.transports)
+}
+
+// Do sends the request through the named proxies concurrently, or through
+// every proxy if no names are given, and returns the results keyed by proxy
+// name. Failures of individual proxies are reported in their result.
+func (f *FanOutClient) Do(req *http.Request, names ...string) (map[string]*FanOutResult, error) {
+	if len(names) == 0 {
+		names = f.Names()
+	}
+	for _, name := range names {
+		if _, ok := f.transports[name]; !ok {
+			return nil, fmt.Errorf("unknown proxy: %s", name)
+		}
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]*FanOutResult, len(names))
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			clone := req.Clone(req.Context())
+			if body != nil {
+				clone.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			result := f.do(name, clone)
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[name] = result
+		}(name)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (f *FanOutClient) do(name string, req *http.Request) *FanOutResult {
+	transport := f.transports[name]
+	result := &FanOutResult{Name: name, ProxyURL: f.proxyURLs[name]}
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		result.Err = err
+		result.Duration = time.Since(start)
+		return result
+	}
+	defer resp.Body.Close()
+	result.Body, result.Err = io.ReadAll(resp.Body)
+	result.Duration = time.Since(start)
+	result.StatusCode = resp.StatusCode
+	result.Header = resp.Header
+	result.Metadata = ResponseMetadata(resp)
+	return result
+}
+
+// defaultDiffIgnoredHeaders are headers that are expected to differ between
+// otherwise identical responses.
+var defaultDiffIgnoredHeaders = []string{
+	"Date",
+	"Age",
+	"Expires",
+	HeaderProxyName,
+	HeaderProxyURL,
+	HeaderDuration,
+	HeaderRoundTrip,
+	HeaderClientSourceIP,
+	HeaderClientUserAgent,
+	HeaderEgressIP,
+	HeaderEnvironmentID,
+}
+
+// FanOutDiff describes the differences between fan-out results. Each field is
+// only populated when the results differ in that respect.
+type FanOutDiff struct {
+	// StatusCodes maps proxy names to status codes
+	StatusCodes map[string]int
+	// Headers maps header names to the value seen by each proxy. A missing
+	// header is reported as an empty string.
+	Headers map[string]map[string]string
+	// Bodies groups proxy names that received identical bodies
+	Bodies []BodyGroup
+	// Errors maps proxy names to the error that occurred
+	Errors map[string]string
+}
+
+// BodyGroup is a set of proxies that received identical response bodies.
+type BodyGroup struct {
+	Names []string
+	Size  int
+	Hash  string
+}
+
+// Diff compares the successful results for status code, header and body
+// differences, and reports any errors. The Date, Age, Expires and X-Burrow-*
+// headers are ignored, along with any additional headers provided.
+func Diff(results map[string]*FanOutResult, ignoreHeaders ...string) *FanOutDiff {
+	ignored := map[string]bool{}
+	for _, h := range append(defaultDiffIgnoredHeaders, ignoreHeaders...) {
+		ignored[http.CanonicalHeaderKey(h)] = true
+	}
+	names := sortedNames(results)
+
+	diff := &FanOutDiff{}
+	statusCodes := map[string]int{}
+	headerNames := map[string]bool{}
+	bodyGroups := map[string]*BodyGroup{}
+	var bodyOrder []string
+	for _, name := range names {
+		result := results[name]
+		if result.Err != nil {
+			if diff.Errors == nil {
+				diff.Errors = map[string]string{}
+			}
+			diff.Errors[name] = result.Err.Error()
+			continue
+		}
+		statusCodes[name] = result.StatusCode
+		for h := range result.Header {
+			if !ignored[h] {
+				headerNames[h] = true
+			}
+		}
+		hash := fmt.Sprintf("%x", sha256.Sum256(result.Body))
+		group, ok := bodyGroups[hash]
+		if !ok {
+			group = &BodyGroup{Size: len(result.Body), Hash: hash}
+			bodyGroups[hash] = group
+			bodyOrder = append(bodyOrder, hash)
+		}
+		group.Names = append(group.Names, name)
+	}
+	if !allEqual(statusCodes) {
+		diff.StatusCodes = statusCodes
+	}
+	for h := range headerNames {
+		values := map[string]string{}
+		for name := range statusCodes {
+			values[name] = strings.Join(results[name].Header.Values(h), ", ")
+		}
+		if !allEqual(values) {
+			if diff.Headers == nil {
+				diff.Headers = map[string]map[string]string{}
+			}
+			diff.Headers[h] = values
+		}
+	}
+	if len(bodyGroups) > 1 {
+		for _, hash := range bodyOrder {
+			diff.Bodies = append(diff.Bodies, *bodyGroups[hash])
+		}
+	}
+	return diff
+}
+
+// Empty returns true if no differences or errors were found.
+func (d *FanOutDiff) Empty() bool {
+	return len(d.StatusCodes) == 0 && len(d.Headers) == 0 && len(d.Bodies) == 0 && len(d.Errors) == 0
+}
+
+// String returns a human readable summary of the differences.
+func (d *FanOutDiff) String() string {
+	if d.Empty() {
+		return "no differences"
+	}
+	var b strings.Builder
+	if len(d.StatusCodes) > 0 {
+		b.WriteString("status:")
+		for _, name := range sortedNames(d.StatusCodes) {
+			fmt.Fprintf(&b, " %s=%d", name, d.StatusCodes[name])
+		}
+		b.WriteString("\n")
+	}
+	for _, h := range sortedNames(d.Headers) {
+		fmt.Fprintf(&b, "header %s:", h)
+		for _, name := range sortedNames(d.Headers[h]) {
+			fmt.Fprintf(&b, " %s=%q", name, d.Headers[h][name])
+		}
+		b.WriteString("\n")
+	}
+	if len(d.Bodies) > 0 {
+		b.WriteString("body:")
+		for _, group := range d.Bodies {
+			fmt.Fprintf(&b, " [%s] %d bytes %s;", strings.Join(group.Names, " "), group.Size, group.Hash[:12])
+		}
+		b.WriteString("\n")
+	}
+	for _, name := range sortedNames(d.Errors) {
+		fmt.Fprintf(&b, "error %s: %s\n", name, d.Errors[name])
+	}
+	return b.String()
+}
+
+func allEqual[V comparable](m map[string]V) bool {
+	var first V
+	seen := false
+	for _, v := range m {
+		if !seen {
+			first, seen = v, true
+			continue
+		}
+		if v != first {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,77 @@
+package burrow
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRegionProxy(region string, status int, body string, headers map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Response{
+			StatusCode: status,
+			Headers:    headers,
+			Body:       base64.StdEncoding.EncodeToString([]byte(body)),
+			ProxyName:  "aws.lambda." + region,
+		})
+	}))
+}
+
+func TestFanOutClient(t *testing.T) {
+	east := newRegionProxy("us-east-1", 200, "hello", map[string]string{"Content-Type": "text/plain", "Date": "a"})
+	defer east.Close()
+	west := newRegionProxy("us-west-2", 200, "hello", map[string]string{"Content-Type": "text/plain", "Date": "b"})
+	defer west.Close()
+	eu := newRegionProxy("eu-west-1", 451, "blocked", map[string]string{"Content-Type": "text/html"})
+	defer eu.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	client := NewFanOutClient(map[string]string{
+		"us-east-1":  east.URL,
+		"us-west-2":  west.URL,
+		"eu-west-1":  eu.URL,
+		"ap-south-1": failing.URL,
+	})
+	assert.Equal(t, []string{"ap-south-1", "eu-west-1", "us-east-1", "us-west-2"}, client.Names())
+
+	req, err := http.NewRequest("POST", "https://example.com", strings.NewReader("body"))
+	require.NoError(t, err)
+	results, err := client.Do(req)
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.Equal(t, "hello", string(results["us-east-1"].Body))
+	assert.Equal(t, "aws.lambda.us-east-1", results["us-east-1"].Metadata.ProxyName)
+	assert.Equal(t, 451, results["eu-west-1"].StatusCode)
+	assert.Error(t, results["ap-south-1"].Err)
+
+	diff := Diff(results)
+	assert.False(t, diff.Empty())
+	assert.Equal(t, map[string]int{"eu-west-1": 451, "us-east-1": 200, "us-west-2": 200}, diff.StatusCodes)
+	assert.Contains(t, diff.Headers, "Content-Type")
+	assert.NotContains(t, diff.Headers, "Date")
+	require.Len(t, diff.Bodies, 2)
+	assert.Equal(t, []string{"eu-west-1"}, diff.Bodies[0].Names)
+	assert.Equal(t, []string{"us-east-1", "us-west-2"}, diff.Bodies[1].Names)
+	assert.Contains(t, diff.Errors, "ap-south-1")
+	assert.Contains(t, diff.String(), "status: eu-west-1=451 us-east-1=200 us-west-2=200")
+
+	req, err = http.NewRequest("GET", "https://example.com", nil)
+	require.NoError(t, err)
+	results, err = client.Do(req, "us-east-1", "us-west-2")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.True(t, Diff(results).Empty())
+	assert.Equal(t, "no differences", Diff(results).String())
+
+	_, err = client.Do(req, "mars-north-1")
+	assert.EqualError(t, err, "unknown proxy: mars-north-1")
+}
@@ -0,0 +1,352 @@
+package burrow
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var _ http.RoundTripper = &HARRecorder{}
+
+// HAR is the root of an HTTP Archive (HAR) 1.2 document.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog contains the entries of a HAR document.
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+// HARCreator identifies the application that created a HAR document.
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is a single request and response in a HAR document. Fields
+// prefixed with an underscore are Burrow-specific custom fields.
+type HAREntry struct {
+	StartedDateTime  time.Time   `json:"startedDateTime"`
+	Time             float64     `json:"time"`
+	Request          HARRequest  `json:"request"`
+	Response         HARResponse `json:"response"`
+	Cache            struct{}    `json:"cache"`
+	Timings          HARTimings  `json:"timings"`
+	ServerIPAddress  string      `json:"serverIPAddress,omitempty"`
+	ProxyName        string      `json:"_proxyName,omitempty"`
+	ProxyRegion      string      `json:"_proxyRegion,omitempty"`
+	ProxyURL         string      `json:"_proxyUrl,omitempty"`
+	UpstreamDuration float64     `json:"_upstreamDuration,omitempty"`
+	Error            string      `json:"_error,omitempty"`
+}
+
+// HARRequest describes a request in a HAR entry.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse describes a response in a HAR entry.
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARNameValue is a name and value pair, used for headers, cookies and
+// query parameters.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData describes a request body.
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARContent describes a response body.
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings contains the duration of each phase of a request in
+// milliseconds. Phases that do not apply are -1. Time spent reaching the
+// proxy is reported as blocked.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// HARRecorder is an http.RoundTripper that captures each request and
+// response passing through it as a HAR entry. Enable diagnostics on the
+// underlying transport to include DNS, connect and TLS timings.
+type HARRecorder struct {
+	next         http.RoundTripper
+	maxBodyBytes int
+	mutex        sync.Mutex
+	entries      []*HAREntry
+}
+
+// NewHARRecorder creates a HARRecorder that sends requests using next.
+// Request and response bodies larger than maxBodyBytes are truncated in the
+// HAR entries. A limit of zero or less omits bodies entirely.
+func NewHARRecorder(next http.RoundTripper, maxBodyBytes int) *HARRecorder {
+	return &HARRecorder{next: next, maxBodyBytes: max(maxBodyBytes, 0)}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (h *HARRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	start := time.Now()
+	resp, err := h.next.RoundTrip(req)
+	roundTrip := time.Since(start)
+
+	entry := &HAREntry{
+		StartedDateTime: start,
+		Time:            milliseconds(roundTrip),
+		Request:         h.harRequest(req, reqBody),
+		Timings:         HARTimings{DNS: -1, Connect: -1, SSL: -1, Wait: milliseconds(roundTrip)},
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Response = HARResponse{
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []HARNameValue{},
+			Headers:     []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		var proxyErr *ProxyError
+		if errors.As(err, &proxyErr) {
+			entry.Response.StatusText = fmt.Sprintf("proxy error %d", proxyErr.Type)
+		}
+		h.add(entry)
+		return nil, err
+	}
+	respBody, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	if readErr != nil {
+		return nil, readErr
+	}
+	entry.Response = h.harResponse(resp, respBody)
+	if md := ResponseMetadata(resp); md != nil {
+		entry.ProxyName = md.ProxyName
+		entry.ProxyURL = md.ProxyURL
+		entry.ProxyRegion = regionOf(md.ProxyURL, md.ProxyName)
+		entry.UpstreamDuration = milliseconds(md.Duration)
+		entry.Timings = harTimings(roundTrip, md)
+		if md.Diagnostics != nil {
+			if host, _, err := net.SplitHostPort(md.Diagnostics.RemoteAddr); err == nil {
+				entry.ServerIPAddress = host
+			}
+		}
+	}
+	h.add(entry)
+	return resp, nil
+}
+
+func (h *HARRecorder) add(entry *HAREntry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.entries = append(h.entries, entry)
+}
+
+// HAR returns a HAR document containing the entries captured so far, ordered
+// by start time.
+func (h *HARRecorder) HAR() *HAR {
+	h.mutex.Lock()
+	entries := make([]*HAREntry, len(h.entries))
+	copy(entries, h.entries)
+	h.mutex.Unlock()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+	return &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "burrow", Version: "1.0"},
+		Entries: entries,
+	}}
+}
+
+// WriteTo writes the captured HAR document as JSON to w.
+func (h *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(h.HAR(), "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+func (h *HARRecorder) harRequest(req *http.Request, body []byte) HARRequest {
+	harReq := HARRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(req.Header),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	for _, cookie := range req.Cookies() {
+		harReq.Cookies = append(harReq.Cookies, HARNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	query := req.URL.Query()
+	for _, name := range sortedKeys(query) {
+		for _, value := range query[name] {
+			harReq.QueryString = append(harReq.QueryString, HARNameValue{Name: name, Value: value})
+		}
+	}
+	if len(body) > 0 && h.maxBodyBytes > 0 {
+		text, _, comment := h.bodyText(body)
+		harReq.PostData = &HARPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     text,
+			Comment:  comment,
+		}
+	}
+	return harReq
+}
+
+func (h *HARRecorder) harResponse(resp *http.Response, body []byte) HARResponse {
+	httpVersion := resp.Proto
+	if httpVersion == "" {
+		httpVersion = "HTTP/1.1"
+	}
+	text, encoding, comment := h.bodyText(body)
+	harResp := HARResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: httpVersion,
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(resp.Header),
+		Content: HARContent{
+			Size:     len(body),
+			MimeType: resp.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	for _, cookie := range resp.Cookies() {
+		harResp.Cookies = append(harResp.Cookies, HARNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	return harResp
+}
+
+// bodyText returns the body as HAR text, truncated to the size limit. Binary
+// bodies are base64 encoded. Nothing is returned if bodies are omitted.
+func (h *HARRecorder) bodyText(body []byte) (text, encoding, comment string) {
+	if h.maxBodyBytes == 0 {
+		return "", "", ""
+	}
+	if len(body) > h.maxBodyBytes {
+		body = body[:h.maxBodyBytes]
+		comment = fmt.Sprintf("truncated to %d bytes", h.maxBodyBytes)
+	}
+	if utf8.Valid(body) {
+		return string(body), "", comment
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64", comment
+}
+
+// harTimings splits the client round trip into HAR phases. Time not spent on
+// the upstream request is reported as blocked. When diagnostics are available
+// the upstream duration is split into DNS, connect, TLS, wait and receive.
+func harTimings(roundTrip time.Duration, md *Metadata) HARTimings {
+	upstream := milliseconds(md.Duration)
+	total := milliseconds(roundTrip)
+	timings := HARTimings{
+		Blocked: max(total-upstream, 0),
+		DNS:     -1,
+		Connect: -1,
+		SSL:     -1,
+		Wait:    upstream,
+	}
+	if md.Diagnostics == nil || md.Diagnostics.Timings == nil {
+		return timings
+	}
+	t := md.Diagnostics.Timings
+	timings.DNS = t.DNS * 1000
+	timings.SSL = t.TLSHandshake * 1000
+	// HAR connect time includes the TLS handshake
+	timings.Connect = (t.Connect + t.TLSHandshake) * 1000
+	// Time to first byte includes the connection phases
+	timings.Wait = max(t.TimeToFirstByte*1000-timings.DNS-timings.Connect, 0)
+	timings.Receive = max(upstream-timings.DNS-timings.Connect-timings.Wait, 0)
+	return timings
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	headers := []HARNameValue{}
+	for _, name := range sortedKeys(header) {
+		for _, value := range header[name] {
+			headers = append(headers, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
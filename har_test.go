@@ -0,0 +1,111 @@
+package burrow
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHARRecorder(t *testing.T) {
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var serReq Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&serReq))
+		if strings.HasSuffix(serReq.URL, "/fail") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ProxyErrorf(ProxyErrBadRequest, "bad request"))
+			return
+		}
+		json.NewEncoder(w).Encode(Response{
+			StatusCode: 200,
+			Headers:    map[string]string{"Content-Type": "text/plain", "Set-Cookie": "session=abc"},
+			Body:       base64.StdEncoding.EncodeToString([]byte("hello world")),
+			ProxyName:  "aws.lambda.eu-west-1",
+			Duration:   0.1,
+			Diagnostics: &Diagnostics{
+				RemoteAddr: "192.0.2.10:443",
+				Protocol:   "HTTP/2.0",
+				Timings: &Timings{
+					DNS:             0.01,
+					Connect:         0.02,
+					TLSHandshake:    0.03,
+					TimeToFirstByte: 0.08,
+				},
+			},
+		})
+	}))
+	defer mockProxy.Close()
+
+	recorder := NewHARRecorder(NewTransportWithOptions(WithProxyURL(mockProxy.URL)), 5)
+	client := &http.Client{Transport: recorder}
+
+	resp, err := client.Post("https://example.com/submit?a=1&b=2", "text/plain", strings.NewReader("payload"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	_, err = client.Get("https://example.com/fail")
+	require.Error(t, err)
+
+	var buf bytes.Buffer
+	_, err = recorder.WriteTo(&buf)
+	require.NoError(t, err)
+	var har HAR
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &har))
+
+	assert.Equal(t, "1.2", har.Log.Version)
+	require.Len(t, har.Log.Entries, 2)
+
+	entry := har.Log.Entries[0]
+	assert.Equal(t, "POST", entry.Request.Method)
+	assert.Equal(t, []HARNameValue{{"a", "1"}, {"b", "2"}}, entry.Request.QueryString)
+	require.NotNil(t, entry.Request.PostData)
+	assert.Equal(t, "paylo", entry.Request.PostData.Text)
+	assert.NotEmpty(t, entry.Request.PostData.Comment)
+	assert.Equal(t, 200, entry.Response.Status)
+	assert.Equal(t, "HTTP/2.0", entry.Response.HTTPVersion)
+	assert.Equal(t, "hello", entry.Response.Content.Text)
+	assert.Equal(t, 11, entry.Response.Content.Size)
+	assert.Equal(t, []HARNameValue{{"session", "abc"}}, entry.Response.Cookies)
+	assert.Equal(t, "aws.lambda.eu-west-1", entry.ProxyName)
+	assert.Equal(t, "eu-west-1", entry.ProxyRegion)
+	assert.Equal(t, "192.0.2.10", entry.ServerIPAddress)
+	assert.InDelta(t, 10, entry.Timings.DNS, 1e-6)
+	assert.InDelta(t, 50, entry.Timings.Connect, 1e-6)
+	assert.InDelta(t, 30, entry.Timings.SSL, 1e-6)
+	assert.InDelta(t, 20, entry.Timings.Wait, 1e-6)
+	assert.InDelta(t, 20, entry.Timings.Receive, 1e-6)
+
+	failed := har.Log.Entries[1]
+	assert.Equal(t, 0, failed.Response.Status)
+	assert.Equal(t, "proxy error [1] bad request", failed.Error)
+}
+
+func TestHARRecorder_OmitBodies(t *testing.T) {
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Response{
+			StatusCode: 200,
+			Body:       base64.StdEncoding.EncodeToString([]byte("hello world")),
+		})
+	}))
+	defer mockProxy.Close()
+
+	for _, limit := range []int{0, -1} {
+		recorder := NewHARRecorder(NewTransportWithOptions(WithProxyURL(mockProxy.URL)), limit)
+		resp, err := (&http.Client{Transport: recorder}).Post("https://example.com", "text/plain", strings.NewReader("payload"))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		entries := recorder.HAR().Log.Entries
+		require.Len(t, entries, 1)
+		assert.Nil(t, entries[0].Request.PostData)
+		assert.Equal(t, 7, entries[0].Request.BodySize)
+		assert.Empty(t, entries[0].Response.Content.Text)
+		assert.Empty(t, entries[0].Response.Content.Comment)
+		assert.Equal(t, 11, entries[0].Response.Content.Size)
+	}
+}
@@ -0,0 +1,148 @@
+package burrow
+
+import (
+	"context"
+	"net/http"
+	"sort"
+)
+
+// HeaderPolicy controls which request headers the proxy sends upstream.
+// Header names are case insensitive.
+type HeaderPolicy struct {
+	// Remove lists headers that are always removed
+	Remove []string
+	// Set lists headers that are always sent, replacing any value from the
+	// client
+	Set map[string]string
+	// Allow, if not empty, lists the only client headers that are forwarded
+	Allow []string
+}
+
+// AnonymousHeaderPolicy returns a strict policy that only forwards headers
+// needed to describe the content of a request. Cookies, credentials,
+// forwarding and tracing headers and the client's user agent are dropped.
+func AnonymousHeaderPolicy() *HeaderPolicy {
+	return &HeaderPolicy{
+		Allow: []string{
+			"Accept",
+			"Accept-Language",
+			"Cache-Control",
+			"Content-Type",
+			"If-Match",
+			"If-Modified-Since",
+			"If-None-Match",
+			"If-Unmodified-Since",
+			"Range",
+		},
+	}
+}
+
+// Apply returns the headers that are sent upstream for the given request
+// headers. The headers passed in are not modified.
+func (p *HeaderPolicy) Apply(headers map[string]string) map[string]string {
+	return p.rules().apply(headers)
+}
+
+// ApplyOrdered is like Apply for headers in order. Headers that are set
+// replace the first client value in place and are otherwise added at the end.
+func (p *HeaderPolicy) ApplyOrdered(fields []HeaderField) []HeaderField {
+	return p.rules().applyOrdered(fields)
+}
+
+// headerRules is a HeaderPolicy with canonical header names.
+type headerRules struct {
+	remove map[string]bool
+	allow  map[string]bool
+	set    map[string]string
+}
+
+func (p *HeaderPolicy) rules() *headerRules {
+	r := &headerRules{
+		remove: canonicalHeaderSet(p.Remove),
+		allow:  canonicalHeaderSet(p.Allow),
+		set:    make(map[string]string, len(p.Set)),
+	}
+	for name, value := range p.Set {
+		r.set[http.CanonicalHeaderKey(name)] = value
+	}
+	return r
+}
+
+func canonicalHeaderSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	return set
+}
+
+// allows reports whether a client header with the canonical name is
+// forwarded.
+func (r *headerRules) allows(name string) bool {
+	if r.remove[name] {
+		return false
+	}
+	return len(r.allow) == 0 || r.allow[name]
+}
+
+func (r *headerRules) apply(headers map[string]string) map[string]string {
+	result := make(map[string]string, len(headers)+len(r.set))
+	for name, value := range headers {
+		name = http.CanonicalHeaderKey(name)
+		if r.allows(name) {
+			result[name] = value
+		}
+	}
+	for name, value := range r.set {
+		result[name] = value
+	}
+	return result
+}
+
+func (r *headerRules) applyOrdered(fields []HeaderField) []HeaderField {
+	written := make(map[string]bool, len(r.set))
+	result := make([]HeaderField, 0, len(fields)+len(r.set))
+	for _, field := range fields {
+		key := http.CanonicalHeaderKey(field.Name)
+		if value, ok := r.set[key]; ok {
+			if !written[key] {
+				result = append(result, HeaderField{Name: field.Name, Value: value})
+				written[key] = true
+			}
+		} else if r.allows(key) {
+			result = append(result, field)
+		}
+	}
+	names := make([]string, 0, len(r.set))
+	for name := range r.set {
+		if !written[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, HeaderField{Name: name, Value: r.set[name]})
+	}
+	return result
+}
+
+// WithHeaderPolicy returns a Handler that applies the policy to the headers
+// of each request before proxying it, including requests in batches. The
+// policy must not be modified afterwards.
+func (h Handler) WithHeaderPolicy(policy *HeaderPolicy) Handler {
+	rules := policy.rules()
+	_, setCookie := rules.set["Cookie"]
+	dropCookies := setCookie || !rules.allows("Cookie")
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		filtered := *req
+		filtered.Headers = rules.apply(req.Headers)
+		if len(req.OrderedHeaders) > 0 {
+			filtered.OrderedHeaders = rules.applyOrdered(req.OrderedHeaders)
+		}
+		// Cookies are sent in addition to the Cookie header
+		if dropCookies {
+			filtered.Cookies = ""
+		}
+		return h(ctx, &filtered)
+	}
+}
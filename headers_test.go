@@ -0,0 +1,147 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHeaderOrigin returns a server that replies with the request headers it
+// received as JSON.
+func newHeaderOrigin() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Header)
+	}))
+}
+
+func TestHeaderPolicy_Apply(t *testing.T) {
+	headers := map[string]string{
+		"accept":          "text/html",
+		"Authorization":   "Bearer proxy-token",
+		"X-Forwarded-For": "10.0.0.1",
+		"User-Agent":      "my-crawler",
+	}
+
+	policy := &HeaderPolicy{
+		Remove: []string{"authorization", "X-FORWARDED-FOR"},
+		Set:    map[string]string{"user-agent": "burrow"},
+	}
+	assert.Equal(t, map[string]string{
+		"Accept":     "text/html",
+		"User-Agent": "burrow",
+	}, policy.Apply(headers))
+
+	policy = &HeaderPolicy{Allow: []string{"Accept", "Authorization"}, Remove: []string{"Authorization"}}
+	assert.Equal(t, map[string]string{"Accept": "text/html"}, policy.Apply(headers))
+
+	assert.Equal(t, map[string]string{"Accept": "text/html"}, AnonymousHeaderPolicy().Apply(headers))
+	assert.Len(t, headers, 4)
+
+	// Ordered headers keep their order and casing
+	policy = &HeaderPolicy{
+		Remove: []string{"X-Forwarded-For"},
+		Set:    map[string]string{"user-agent": "burrow", "X-Proxy": "1"},
+	}
+	assert.Equal(t, []HeaderField{
+		{"user-agent", "burrow"},
+		{"accept", "text/html"},
+		{"X-Proxy", "1"},
+	}, policy.ApplyOrdered([]HeaderField{
+		{"user-agent", "my-crawler"},
+		{"x-forwarded-for", "10.0.0.1"},
+		{"accept", "text/html"},
+		{"User-Agent", "duplicate"},
+	}))
+}
+
+func TestHandler_WithHeaderPolicy(t *testing.T) {
+	origin := newHeaderOrigin()
+	defer origin.Close()
+
+	handler := GetHandler().WithHeaderPolicy(AnonymousHeaderPolicy())
+	resp, err := handler(context.Background(), &Request{
+		URL: origin.URL,
+		Headers: map[string]string{
+			"Accept":          "application/json",
+			"Authorization":   "Bearer proxy-token",
+			"Traceparent":     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"User-Agent":      "my-crawler",
+			"Via":             "1.1 corporate-proxy",
+			"X-Forwarded-For": "10.0.0.1",
+		},
+		Cookies: "session=abc",
+	})
+	require.NoError(t, err)
+	httpResp, err := DeserializeResponse(resp)
+	require.NoError(t, err)
+	var received http.Header
+	require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&received))
+
+	assert.Equal(t, "application/json", received.Get("Accept"))
+	assert.Equal(t, "Go-http-client/1.1", received.Get("User-Agent"))
+	for _, name := range []string{"Authorization", "Cookie", "Traceparent", "Via", "X-Forwarded-For"} {
+		assert.Empty(t, received.Get(name), name)
+	}
+
+	// Batches are filtered too
+	batch, err := handler.HandleBatch(context.Background(), &BatchRequest{
+		Requests: []*Request{{URL: origin.URL, Headers: map[string]string{"Authorization": "Bearer proxy-token"}}},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, batch.Results[0].Response)
+	httpResp, err = DeserializeResponse(batch.Results[0].Response)
+	require.NoError(t, err)
+	received = nil
+	require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&received))
+	assert.Empty(t, received.Get("Authorization"))
+}
+
+func TestHandler_WithHeaderPolicySetCookie(t *testing.T) {
+	origin := newHeaderOrigin()
+	defer origin.Close()
+
+	// A cookie that is set replaces the client's cookies
+	handler := GetHandler().WithHeaderPolicy(&HeaderPolicy{Set: map[string]string{"cookie": "consent=yes"}})
+	resp, err := handler(context.Background(), &Request{
+		URL:     origin.URL,
+		Headers: map[string]string{"Cookie": "session=header"},
+		Cookies: "session=abc",
+	})
+	require.NoError(t, err)
+	httpResp, err := DeserializeResponse(resp)
+	require.NoError(t, err)
+	var received http.Header
+	require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&received))
+	assert.Equal(t, []string{"consent=yes"}, received.Values("Cookie"))
+}
+
+func TestClient_WithStripHeaders(t *testing.T) {
+	origin := newHeaderOrigin()
+	defer origin.Close()
+	function := httptest.NewServer(NewHTTPHandler(GetHandler()))
+	defer function.Close()
+
+	client := NewClient(WithProxyURL(function.URL), WithStripHeaders("x-forwarded-for", "Traceparent"))
+	client.Timeout = 10 * time.Second
+
+	req, err := http.NewRequest("GET", origin.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("X-Api-Key", "upstream-key")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var received http.Header
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&received))
+
+	assert.Equal(t, "upstream-key", received.Get("X-Api-Key"))
+	assert.Empty(t, received.Get("X-Forwarded-For"))
+	assert.Empty(t, received.Get("Traceparent"))
+}
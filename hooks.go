@@ -0,0 +1,165 @@
+package burrow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrorClass is a coarse classification of an error that occurred while
+// proxying a request.
+type ErrorClass string
+
+const (
+	// ErrorClassNone indicates there was no error
+	ErrorClassNone ErrorClass = ""
+	// ErrorClassProxy indicates the proxy returned a ProxyError
+	ErrorClassProxy ErrorClass = "proxy"
+	// ErrorClassThrottled indicates the proxy was throttled and did not run,
+	// i.e. a ProxyError of type ProxyErrThrottled
+	ErrorClassThrottled ErrorClass = "throttled"
+	// ErrorClassTransport indicates the proxy could not be reached
+	ErrorClassTransport ErrorClass = "transport"
+	// ErrorClassProtocol indicates the proxy returned an invalid response
+	ErrorClassProtocol ErrorClass = "protocol"
+	// ErrorClassCanceled indicates the request context was canceled or expired
+	ErrorClassCanceled ErrorClass = "canceled"
+)
+
+// ClassifyError returns the ErrorClass for an error returned by a Burrow
+// transport.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorClassNone
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassCanceled
+	}
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		if proxyErr.Type == ProxyErrThrottled {
+			return ErrorClassThrottled
+		}
+		return ErrorClassProxy
+	}
+	var protoErr *protocolError
+	if errors.As(err, &protoErr) {
+		return ErrorClassProtocol
+	}
+	return ErrorClassTransport
+}
+
+// protocolError wraps errors caused by a malformed proxy response.
+type protocolError struct {
+	err error
+}
+
+func (e *protocolError) Error() string { return e.err.Error() }
+
+func (e *protocolError) Unwrap() error { return e.err }
+
+// HookEvent describes a point in the lifecycle of a proxied request.
+type HookEvent struct {
+	// Request is the original request being proxied
+	Request *http.Request
+	// Attempt is the 1-based attempt number for this request
+	Attempt int
+	// ProxyURL is the URL of the selected proxy
+	ProxyURL string
+	// ProxyName is the name reported by the proxy, if a response was received
+	ProxyName string
+	// StatusCode is the upstream status code, if a response was received
+	StatusCode int
+	// Err is the error that occurred, if any
+	Err error
+	// ErrorClass classifies Err
+	ErrorClass ErrorClass
+	// ErrorType is the ProxyError type when Err is a ProxyError
+	ErrorType ErrorCode
+	// Duration is the time spent on this attempt so far
+	Duration time.Duration
+	// Backoff is the delay before the next attempt (OnRetry only)
+	Backoff time.Duration
+	// Cooldown is how long the proxy is out of rotation (OnCircuitOpen only)
+	Cooldown time.Duration
+	// Response is the deserialized proxy response (OnResponse only)
+	Response *Response
+}
+
+// HookFunc is a function invoked with a HookEvent.
+type HookFunc func(ctx context.Context, event *HookEvent)
+
+// Hooks contains optional functions invoked during the lifecycle of proxied
+// requests. OnRequest, OnProxyError and OnResponse are invoked by Transport
+// for each attempt. OnRetry and OnCircuitOpen are invoked by
+// RoundRobinTransport.
+type Hooks struct {
+	// OnRequest is called before a request is sent to a proxy
+	OnRequest HookFunc
+	// OnRetry is called before a request is retried
+	OnRetry HookFunc
+	// OnProxyError is called when proxying a request fails
+	OnProxyError HookFunc
+	// OnResponse is called when a proxy returns a response
+	OnResponse HookFunc
+	// OnCircuitOpen is called when a proxy is temporarily taken out of rotation
+	OnCircuitOpen HookFunc
+}
+
+func (h *Hooks) onRequest(ctx context.Context, event *HookEvent) {
+	if h != nil && h.OnRequest != nil {
+		h.OnRequest(ctx, event)
+	}
+}
+
+func (h *Hooks) onRetry(ctx context.Context, event *HookEvent) {
+	if h != nil && h.OnRetry != nil {
+		classifyEventError(event)
+		h.OnRetry(ctx, event)
+	}
+}
+
+func (h *Hooks) onProxyError(ctx context.Context, event *HookEvent) {
+	if h != nil && h.OnProxyError != nil {
+		classifyEventError(event)
+		h.OnProxyError(ctx, event)
+	}
+}
+
+// classifyEventError sets the ErrorClass and ErrorType of the event's Err.
+func classifyEventError(event *HookEvent) {
+	event.ErrorClass = ClassifyError(event.Err)
+	var proxyErr *ProxyError
+	if errors.As(event.Err, &proxyErr) {
+		event.ErrorType = proxyErr.Type
+	}
+}
+
+func (h *Hooks) onResponse(ctx context.Context, event *HookEvent) {
+	if h != nil && h.OnResponse != nil {
+		h.OnResponse(ctx, event)
+	}
+}
+
+func (h *Hooks) onCircuitOpen(ctx context.Context, event *HookEvent) {
+	if h != nil && h.OnCircuitOpen != nil {
+		h.OnCircuitOpen(ctx, event)
+	}
+}
+
+type attemptKey struct{}
+
+// withAttempt returns a context carrying the attempt number of a request.
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// attemptFromContext returns the attempt number stored in the context,
+// defaulting to 1.
+func attemptFromContext(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
@@ -0,0 +1,199 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type hookRecorder struct {
+	mutex  sync.Mutex
+	events map[string][]HookEvent
+}
+
+func (h *hookRecorder) record(name string) HookFunc {
+	return func(ctx context.Context, event *HookEvent) {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		h.events[name] = append(h.events[name], *event)
+	}
+}
+
+func (h *hookRecorder) hooks() *Hooks {
+	return &Hooks{
+		OnRequest:     h.record("request"),
+		OnRetry:       h.record("retry"),
+		OnProxyError:  h.record("proxy_error"),
+		OnResponse:    h.record("response"),
+		OnCircuitOpen: h.record("circuit_open"),
+	}
+}
+
+func newStatusProxy(name string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Response{StatusCode: status, ProxyName: name})
+	}))
+}
+
+func TestHooks_Retry(t *testing.T) {
+	unavailable := newStatusProxy("unavailable", http.StatusServiceUnavailable)
+	defer unavailable.Close()
+	ok := newStatusProxy("ok", http.StatusOK)
+	defer ok.Close()
+
+	recorder := &hookRecorder{events: map[string][]HookEvent{}}
+	client := NewClient(
+		WithProxyURLs([]string{unavailable.URL, ok.URL}),
+		WithRetries(1),
+		WithHooks(recorder.hooks()),
+	)
+	resp, err := client.Get("https://example.com")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	requests := recorder.events["request"]
+	require.Len(t, requests, 2)
+	assert.Equal(t, 1, requests[0].Attempt)
+	assert.Equal(t, unavailable.URL, requests[0].ProxyURL)
+	assert.Equal(t, 2, requests[1].Attempt)
+	assert.Equal(t, ok.URL, requests[1].ProxyURL)
+
+	retries := recorder.events["retry"]
+	require.Len(t, retries, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, retries[0].StatusCode)
+	assert.Equal(t, 100*time.Millisecond, retries[0].Backoff)
+
+	responses := recorder.events["response"]
+	require.Len(t, responses, 2)
+	assert.Equal(t, "ok", responses[1].ProxyName)
+	assert.Empty(t, recorder.events["proxy_error"])
+}
+
+func TestHooks_ProxyErrorAndCircuit(t *testing.T) {
+	var failedCalls int
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failedCalls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	ok := newStatusProxy("ok", http.StatusOK)
+	defer ok.Close()
+
+	recorder := &hookRecorder{events: map[string][]HookEvent{}}
+	client := NewClient(
+		WithProxyURLs([]string{failing.URL, ok.URL}),
+		WithHooks(recorder.hooks()),
+		WithCircuitBreaker(1, time.Minute),
+	)
+
+	_, err := client.Get("https://example.com")
+	require.Error(t, err)
+	var proxyErr *ProxyError
+	require.True(t, errors.As(err, &proxyErr))
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(fmt.Sprintf("https://example.com/%d", i))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, 1, failedCalls)
+
+	proxyErrors := recorder.events["proxy_error"]
+	require.Len(t, proxyErrors, 1)
+	assert.Equal(t, ErrorClassProxy, proxyErrors[0].ErrorClass)
+	assert.Equal(t, ProxyErrUnknown, proxyErrors[0].ErrorType)
+
+	circuits := recorder.events["circuit_open"]
+	require.Len(t, circuits, 1)
+	assert.Equal(t, failing.URL, circuits[0].ProxyURL)
+	assert.Equal(t, time.Minute, circuits[0].Cooldown)
+}
+
+func TestRoundRobinTransport_CircuitReopens(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	ok := newStatusProxy("ok", http.StatusOK)
+	defer ok.Close()
+
+	recorder := &hookRecorder{events: map[string][]HookEvent{}}
+	client := NewClient(
+		WithProxyURLs([]string{failing.URL, ok.URL}),
+		WithHooks(recorder.hooks()),
+		WithCircuitBreaker(2, 50*time.Millisecond),
+	)
+	get := func() error {
+		resp, err := client.Get("https://example.com")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// Two consecutive failures open the circuit
+	require.Error(t, get())
+	require.NoError(t, get())
+	require.Error(t, get())
+	require.Len(t, recorder.events["circuit_open"], 1)
+	require.NoError(t, get())
+	require.NoError(t, get())
+
+	// After the cooldown the proxy is tried again and one failure reopens it
+	time.Sleep(60 * time.Millisecond)
+	require.Error(t, get())
+	require.Len(t, recorder.events["circuit_open"], 2)
+	require.NoError(t, get())
+	require.NoError(t, get())
+}
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, ErrorClassNone, ClassifyError(nil))
+	assert.Equal(t, ErrorClassProxy, ClassifyError(ProxyErrorf(ProxyErrTimeout, "timeout")))
+	assert.Equal(t, ErrorClassThrottled, ClassifyError(ProxyErrorf(ProxyErrThrottled, "rate exceeded")))
+	assert.Equal(t, ErrorClassCanceled, ClassifyError(fmt.Errorf("wrapped: %w", context.Canceled)))
+	assert.Equal(t, ErrorClassProtocol, ClassifyError(&protocolError{errors.New("bad json")}))
+	assert.Equal(t, ErrorClassTransport, ClassifyError(errors.New("connection refused")))
+}
+
+func TestRoundRobinTransport_RetriesThrottling(t *testing.T) {
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"Message":"Rate Exceeded."}`))
+	}))
+	defer throttled.Close()
+	ok := newStatusProxy("ok", http.StatusOK)
+	defer ok.Close()
+
+	recorder := &hookRecorder{events: map[string][]HookEvent{}}
+	client := NewClient(
+		WithProxyURLs([]string{throttled.URL, ok.URL}),
+		WithRetries(1),
+		WithHooks(recorder.hooks()),
+	)
+	resp, err := client.Get("https://example.com")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "ok", ResponseMetadata(resp).ProxyName)
+	require.Len(t, recorder.events["retry"], 1)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.events["retry"][0].StatusCode)
+	assert.Error(t, recorder.events["retry"][0].Err)
+	assert.Equal(t, ErrorClassThrottled, recorder.events["retry"][0].ErrorClass)
+	assert.Equal(t, ProxyErrThrottled, recorder.events["retry"][0].ErrorType)
+	require.Len(t, recorder.events["proxy_error"], 1)
+	assert.Equal(t, ErrorClassThrottled, recorder.events["proxy_error"][0].ErrorClass)
+
+	// Once the retries are used up the throttling error is returned
+	_, err = NewClient(WithProxyURL(throttled.URL), WithRetries(1)).Get("https://example.com")
+	assert.Equal(t, ErrorClassThrottled, ClassifyError(err))
+}
@@ -0,0 +1,184 @@
+package burrow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// lambdaInvokeUserAgent is reported to the function as the client user agent
+// when it is invoked through the Lambda Invoke API.
+const lambdaInvokeUserAgent = "burrow"
+
+// lambdaInvokeEvent is the Function URL event sent to the function when it is
+// invoked through the Lambda Invoke API, so that the same handler serves both.
+type lambdaInvokeEvent struct {
+	Version        string                   `json:"version"`
+	Body           string                   `json:"body"`
+	RequestContext lambdaInvokeEventContext `json:"requestContext"`
+}
+
+type lambdaInvokeEventContext struct {
+	HTTP lambdaInvokeEventHTTP `json:"http"`
+}
+
+type lambdaInvokeEventHTTP struct {
+	Method    string `json:"method"`
+	UserAgent string `json:"userAgent"`
+}
+
+// lambdaInvokeResult is the Function URL response returned by the function.
+type lambdaInvokeResult struct {
+	StatusCode int    `json:"statusCode"`
+	Body       string `json:"body"`
+}
+
+// lambdaFunctionError is returned by the Invoke API if the function failed.
+type lambdaFunctionError struct {
+	ErrorMessage string `json:"errorMessage"`
+	ErrorType    string `json:"errorType"`
+}
+
+// LambdaInvokeURL returns the Lambda Invoke API URL for a function in a
+// region. The function may be a name, a partial or full ARN, and may include
+// a qualifier, e.g. "burrow:live".
+func LambdaInvokeURL(region, function string) string {
+	return lambdaInvokeURL(fmt.Sprintf("https://lambda.%s.amazonaws.com", region), function)
+}
+
+func lambdaInvokeURL(endpoint, function string) string {
+	return fmt.Sprintf("%s/2015-03-31/functions/%s/invocations", endpoint, sigV4Escape(function, true))
+}
+
+// LambdaBackend invokes a Burrow function through the Lambda Invoke API
+// rather than a Function URL. Requests are signed with SigV4, so the function
+// does not need a Function URL and callers need the lambda:InvokeFunction
+// permission.
+type LambdaBackend struct {
+	function string
+	endpoint string
+	client   *http.Client
+	signer   *sigV4Signer
+}
+
+// NewLambdaBackend creates a backend that invokes the function in the region
+// using the provided credentials, or DefaultCredentials if nil.
+func NewLambdaBackend(function, region string, credentials CredentialsProvider) *LambdaBackend {
+	if credentials == nil {
+		credentials = DefaultCredentials()
+	}
+	return &LambdaBackend{
+		function: function,
+		endpoint: fmt.Sprintf("https://lambda.%s.amazonaws.com", region),
+		client:   &http.Client{},
+		signer:   newSigV4Signer(credentials, region, lambdaService),
+	}
+}
+
+// NewLambdaTransport creates a Transport that invokes a Burrow function
+// through the Lambda Invoke API. See NewLambdaBackend.
+func NewLambdaTransport(function, region string, credentials CredentialsProvider) *Transport {
+	return NewTransportWithBackend(NewLambdaBackend(function, region, credentials))
+}
+
+// WithEndpoint sets the Lambda API endpoint, e.g. for a VPC endpoint or a
+// local emulator
+func (b *LambdaBackend) WithEndpoint(endpoint string) *LambdaBackend {
+	b.endpoint = endpoint
+	return b
+}
+
+// WithClient sets the HTTP client used to call the Lambda API
+func (b *LambdaBackend) WithClient(client *http.Client) *LambdaBackend {
+	b.client = client
+	return b
+}
+
+// URL returns the Invoke API URL of the function
+func (b *LambdaBackend) URL() string {
+	return lambdaInvokeURL(b.endpoint, b.function)
+}
+
+// Invoke implements the Backend interface
+func (b *LambdaBackend) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	event, err := wrapInvokePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", b.URL(), bytes.NewReader(event))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := b.signer.sign(ctx, req, event); err != nil {
+		return nil, err
+	}
+	resp, body, err := doProxyRequest(b.client, req)
+	if err != nil {
+		return nil, err
+	}
+	statusCode, body, err := unwrapInvokeResult(resp, body)
+	if err != nil {
+		return nil, err
+	}
+	return proxyReply(statusCode, body)
+}
+
+// wrapInvokePayload wraps a proxy payload in a Function URL event.
+func wrapInvokePayload(payload []byte) ([]byte, error) {
+	event, err := json.Marshal(lambdaInvokeEvent{
+		Version: "2.0",
+		Body:    string(payload),
+		RequestContext: lambdaInvokeEventContext{
+			HTTP: lambdaInvokeEventHTTP{Method: "POST", UserAgent: lambdaInvokeUserAgent},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal invoke event: %w", err)
+	}
+	return event, nil
+}
+
+// unwrapInvokeResult returns the status code and body of the Function URL
+// response in an Invoke API response.
+func unwrapInvokeResult(resp *http.Response, body []byte) (int, []byte, error) {
+	if resp.StatusCode != http.StatusOK {
+		// The API reports errors as {"Message": ...} or {"message": ...}
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(body, &apiErr)
+		// The API rejected the invocation, so the function didn't run
+		return 0, nil, &invocationError{err: &ProxyError{
+			Message: fmt.Sprintf("lambda invoke returned status %d: %s", resp.StatusCode, apiErr.Message),
+			Type:    invokeErrorCode(resp.StatusCode),
+		}}
+	}
+	if resp.Header.Get("X-Amz-Function-Error") != "" {
+		var fnErr lambdaFunctionError
+		json.Unmarshal(body, &fnErr)
+		return 0, nil, &ProxyError{
+			Message: fmt.Sprintf("lambda function error: %s: %s", fnErr.ErrorType, fnErr.ErrorMessage),
+			Type:    ProxyErrUnknown,
+		}
+	}
+	var result lambdaInvokeResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, nil, &protocolError{fmt.Errorf("failed to unmarshal invoke result: %w", err)}
+	}
+	return result.StatusCode, []byte(result.Body), nil
+}
+
+// invokeErrorCode returns the ErrorCode for an Invoke API error status, so
+// that throttling and service errors can be told apart from other failures.
+func invokeErrorCode(statusCode int) ErrorCode {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return ProxyErrThrottled
+	case statusCode >= 500:
+		return ProxyErrUnavailable
+	}
+	return ProxyErrUnknown
+}
@@ -0,0 +1,97 @@
+package burrow
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers synthesized on proxied responses when metadata headers are enabled.
+const (
+	HeaderProxyName       = "X-Burrow-Proxy-Name"
+	HeaderProxyURL        = "X-Burrow-Proxy-Url"
+	HeaderDuration        = "X-Burrow-Duration"
+	HeaderRoundTrip       = "X-Burrow-Round-Trip"
+	HeaderClientSourceIP  = "X-Burrow-Client-Source-Ip"
+	HeaderClientUserAgent = "X-Burrow-Client-User-Agent"
+	HeaderEgressIP        = "X-Burrow-Egress-Ip"
+	HeaderEnvironmentID   = "X-Burrow-Environment-Id"
+)
+
+// Metadata describes how a response was obtained through a Burrow proxy.
+type Metadata struct {
+	// ProxyName is the name reported by the proxy, e.g. "aws.lambda.us-east-1"
+	ProxyName string
+	// ProxyURL is the URL of the proxy that served the request
+	ProxyURL string
+	// Duration is the time the proxy spent fetching the upstream response
+	Duration time.Duration
+	// RoundTrip is the total time spent on the proxy request by the client,
+	// including proxy overhead
+	RoundTrip time.Duration
+	// ClientDetails describes the client as seen by the proxy
+	ClientDetails *ClientDetails
+	// Diagnostics contains upstream connection details, if enabled
+	Diagnostics *Diagnostics
+	// EgressIP is the public IP address the proxy sent the request from, if
+	// reported by the proxy
+	EgressIP string
+	// EnvironmentID identifies the execution environment that served the
+	// request, if reported by the proxy
+	EnvironmentID string
+}
+
+type metadataKey struct{}
+
+// ResponseMetadata returns the proxy metadata attached to a response returned
+// by a Burrow transport. It returns nil if the response was not proxied.
+func ResponseMetadata(resp *http.Response) *Metadata {
+	if resp == nil || resp.Request == nil {
+		return nil
+	}
+	md, _ := resp.Request.Context().Value(metadataKey{}).(*Metadata)
+	return md
+}
+
+// newMetadata builds the Metadata for a proxy response.
+func newMetadata(proxyURL string, serResp *Response, roundTrip time.Duration) *Metadata {
+	return &Metadata{
+		ProxyName:     serResp.ProxyName,
+		ProxyURL:      proxyURL,
+		Duration:      time.Duration(serResp.Duration * float64(time.Second)),
+		RoundTrip:     roundTrip,
+		ClientDetails: serResp.ClientDetails,
+		Diagnostics:   serResp.Diagnostics,
+		EgressIP:      serResp.EgressIP,
+		EnvironmentID: serResp.EnvironmentID,
+	}
+}
+
+// attachMetadata associates the metadata with the response so that it can be
+// retrieved with ResponseMetadata. The original request is not modified.
+func attachMetadata(req *http.Request, resp *http.Response, md *Metadata) {
+	ctx := context.WithValue(req.Context(), metadataKey{}, md)
+	resp.Request = req.WithContext(ctx)
+}
+
+// setMetadataHeaders adds X-Burrow-* headers describing the metadata to the
+// response headers.
+func setMetadataHeaders(h http.Header, md *Metadata) {
+	if md.ProxyName != "" {
+		h.Set(HeaderProxyName, md.ProxyName)
+	}
+	h.Set(HeaderProxyURL, md.ProxyURL)
+	h.Set(HeaderDuration, strconv.FormatFloat(md.Duration.Seconds(), 'f', -1, 64))
+	h.Set(HeaderRoundTrip, strconv.FormatFloat(md.RoundTrip.Seconds(), 'f', -1, 64))
+	if md.ClientDetails != nil {
+		h.Set(HeaderClientSourceIP, md.ClientDetails.SourceIP)
+		h.Set(HeaderClientUserAgent, md.ClientDetails.UserAgent)
+	}
+	if md.EgressIP != "" {
+		h.Set(HeaderEgressIP, md.EgressIP)
+	}
+	if md.EnvironmentID != "" {
+		h.Set(HeaderEnvironmentID, md.EnvironmentID)
+	}
+}
@@ -0,0 +1,51 @@
+package burrow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseMetadata(t *testing.T) {
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Response{
+			StatusCode: 200,
+			Duration:   1.5,
+			ProxyName:  "aws.lambda.us-east-1",
+			ClientDetails: &ClientDetails{
+				SourceIP:  "192.0.2.1",
+				UserAgent: "Go-http-client/1.1",
+			},
+		})
+	}))
+	defer mockProxy.Close()
+
+	client := NewClient(WithProxyURL(mockProxy.URL), WithMetadataHeaders(true))
+	client.Timeout = 10 * time.Second
+
+	resp, err := client.Get("https://example.com")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	md := ResponseMetadata(resp)
+	require.NotNil(t, md)
+	assert.Equal(t, "aws.lambda.us-east-1", md.ProxyName)
+	assert.Equal(t, mockProxy.URL, md.ProxyURL)
+	assert.Equal(t, 1500*time.Millisecond, md.Duration)
+	assert.Greater(t, md.RoundTrip, time.Duration(0))
+	assert.Equal(t, "192.0.2.1", md.ClientDetails.SourceIP)
+
+	assert.Equal(t, "aws.lambda.us-east-1", resp.Header.Get(HeaderProxyName))
+	assert.Equal(t, "1.5", resp.Header.Get(HeaderDuration))
+	assert.Equal(t, "192.0.2.1", resp.Header.Get(HeaderClientSourceIP))
+}
+
+func TestResponseMetadata_NotProxied(t *testing.T) {
+	assert.Nil(t, ResponseMetadata(nil))
+	assert.Nil(t, ResponseMetadata(&http.Response{}))
+}
@@ -0,0 +1,88 @@
+package burrow
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Metrics records measurements of proxied requests. Implementations must be
+// safe for concurrent use.
+type Metrics interface {
+	// ObserveRequest records the outcome of a single attempt to proxy a request
+	ObserveRequest(obs *RequestObservation)
+	// ObserveRetry records that a request will be retried after receiving a
+	// retryable status code from the given proxy
+	ObserveRetry(proxyURL string, statusCode int)
+}
+
+// RequestObservation describes a single attempt to proxy a request.
+type RequestObservation struct {
+	// ProxyURL is the URL of the proxy used
+	ProxyURL string
+	// ProxyName is the name reported by the proxy, if a response was received
+	ProxyName string
+	// StatusCode is the upstream status code, if a response was received
+	StatusCode int
+	// Err is the error that occurred, if any
+	Err error
+	// ErrorClass classifies Err
+	ErrorClass ErrorClass
+	// ErrorType is the ProxyError type when Err is a ProxyError
+	ErrorType ErrorCode
+	// Duration is the total time spent on the attempt by the client
+	Duration time.Duration
+	// UpstreamDuration is the time the proxy reported spending on the
+	// upstream request
+	UpstreamDuration time.Duration
+	// BytesSent is the size of the request envelope sent to the proxy
+	BytesSent int64
+	// BytesReceived is the size of the response envelope received from the proxy
+	BytesReceived int64
+}
+
+// ProxyOverhead returns the portion of the request duration not spent on the
+// upstream request, e.g. network latency to the proxy and invocation overhead.
+func (o *RequestObservation) ProxyOverhead() time.Duration {
+	if o.UpstreamDuration > o.Duration {
+		return 0
+	}
+	return o.Duration - o.UpstreamDuration
+}
+
+// StatusClass returns the status class of the upstream response, e.g. "2xx",
+// or "error" if the request could not be proxied.
+func (o *RequestObservation) StatusClass() string {
+	if o.Err != nil || o.StatusCode < 100 || o.StatusCode > 599 {
+		return "error"
+	}
+	return strconv.Itoa(o.StatusCode/100) + "xx"
+}
+
+// newRequestObservation builds the observation for a completed attempt.
+func newRequestObservation(proxyURL string, serResp *Response, err error, duration time.Duration, stats *roundTripStats) *RequestObservation {
+	obs := &RequestObservation{
+		ProxyURL:      proxyURL,
+		Err:           err,
+		ErrorClass:    ClassifyError(err),
+		Duration:      duration,
+		BytesSent:     stats.sent,
+		BytesReceived: stats.received,
+	}
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		obs.ErrorType = proxyErr.Type
+	}
+	if serResp != nil {
+		obs.ProxyName = serResp.ProxyName
+		obs.StatusCode = serResp.StatusCode
+		obs.UpstreamDuration = time.Duration(serResp.Duration * float64(time.Second))
+	}
+	return obs
+}
+
+// roundTripStats counts the bytes transferred to and from a proxy.
+type roundTripStats struct {
+	sent     int64
+	received int64
+}
@@ -0,0 +1,13 @@
+package burrow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestObservation(t *testing.T) {
+	obs := &RequestObservation{StatusCode: 404, Duration: 3e9, UpstreamDuration: 1e9}
+	assert.Equal(t, "4xx", obs.StatusClass())
+	assert.Equal(t, float64(2), obs.ProxyOverhead().Seconds())
+}
@@ -0,0 +1,369 @@
+package burrow
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orderedHeaderTransport is an http.RoundTripper that writes HTTP/1.1
+// requests itself, so that headers are sent in a given order with their
+// original casing. net/http sorts headers and canonicalizes their names.
+// Like net/http it asks for gzip and decompresses responses, unless the
+// request sets Accept-Encoding itself. Unlike net/http, connections are not
+// reused: a new one is made for each request. Like the proxy's default
+// transport, it ignores HTTP_PROXY and related variables.
+type orderedHeaderTransport struct {
+	dialer                *net.Dialer
+	tlsDialer             TLSDialer
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+}
+
+var defaultOrderedHeaderTransport = newOrderedHeaderTransport(NewGoTLSDialer(nil))
+
+func newOrderedHeaderTransport(tlsDialer TLSDialer) *orderedHeaderTransport {
+	return &orderedHeaderTransport{
+		dialer: &net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+		tlsDialer:             tlsDialer,
+		tlsHandshakeTimeout:   5 * time.Second,
+		responseHeaderTimeout: 10 * time.Second,
+	}
+}
+
+type headerOrderKey struct{}
+
+// withHeaderOrder returns a context whose requests are sent with headers in
+// the order and casing of fields. It applies to redirects too.
+func withHeaderOrder(ctx context.Context, fields []HeaderField) context.Context {
+	return context.WithValue(ctx, headerOrderKey{}, fields)
+}
+
+func headerOrderFromContext(ctx context.Context) []HeaderField {
+	fields, _ := ctx.Value(headerOrderKey{}).([]HeaderField)
+	return fields
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *orderedHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	ctx := req.Context()
+	trace := httptrace.ContextClientTrace(ctx)
+	conn, err := t.dial(ctx, req, trace)
+	if err != nil {
+		return nil, err
+	}
+	// Unblock reads and writes if the request is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	closeConn := func() {
+		stop()
+		conn.Close()
+	}
+	if trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: conn})
+	}
+	// Ask for gzip as net/http does, unless the caller chose an encoding
+	outReq := req
+	requestedGzip := req.Header.Get("Accept-Encoding") == "" &&
+		req.Header.Get("Range") == "" &&
+		req.Method != http.MethodHead
+	if requestedGzip {
+		outReq = new(http.Request)
+		*outReq = *req
+		outReq.Header = req.Header.Clone()
+		if outReq.Header == nil {
+			outReq.Header = http.Header{}
+		}
+		outReq.Header.Set("Accept-Encoding", "gzip")
+	}
+	bw := bufio.NewWriter(conn)
+	err = writeOrderedRequest(bw, outReq, headerOrderFromContext(ctx))
+	if err == nil {
+		err = bw.Flush()
+	}
+	if trace != nil && trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{Err: err})
+	}
+	if err != nil {
+		closeConn()
+		return nil, contextError(ctx, fmt.Errorf("failed to write request: %w", err))
+	}
+	conn.SetReadDeadline(time.Now().Add(t.responseHeaderTimeout))
+	br := bufio.NewReader(conn)
+	if _, err := br.Peek(1); err == nil && trace != nil && trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+	var resp *http.Response
+	for {
+		resp, err = http.ReadResponse(br, req)
+		if err != nil {
+			closeConn()
+			return nil, contextError(ctx, fmt.Errorf("failed to read response: %w", err))
+		}
+		// Skip informational responses, e.g. 103 Early Hints
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+	if tlsConn, ok := conn.(TLSConn); ok {
+		state := tlsConn.ConnectionState()
+		resp.TLS = &state
+	}
+	if requestedGzip && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+		resp.Body = &gzipBody{body: resp.Body}
+	}
+	resp.Body = &connBody{ReadCloser: resp.Body, close: closeConn}
+	return resp, nil
+}
+
+// dial connects to the host of the request, with TLS for https.
+func (t *orderedHeaderTransport) dial(ctx context.Context, req *http.Request, trace *httptrace.ClientTrace) (net.Conn, error) {
+	addr := canonicalAddr(req)
+	if trace != nil && trace.GetConn != nil {
+		trace.GetConn(addr)
+	}
+	if req.URL.Scheme == "https" {
+		return t.dialTLS(ctx, "tcp", addr, trace, []string{"http/1.1"})
+	}
+	return t.dialTCP(ctx, "tcp", addr, trace)
+}
+
+func (t *orderedHeaderTransport) dialTCP(ctx context.Context, network, addr string, trace *httptrace.ClientTrace) (net.Conn, error) {
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart(network, addr)
+	}
+	conn, err := t.dialer.DialContext(ctx, network, addr)
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone(network, addr, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// dialTLS connects to addr and performs the TLS handshake with the
+// transport's TLSDialer, offering the protocols with ALPN.
+func (t *orderedHeaderTransport) dialTLS(ctx context.Context, network, addr string, trace *httptrace.ClientTrace, protocols []string) (TLSConn, error) {
+	conn, err := t.dialTCP(ctx, network, addr, trace)
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	handshakeCtx, cancel := context.WithTimeout(ctx, t.tlsHandshakeTimeout)
+	defer cancel()
+	tlsConn, err := t.tlsDialer.DialTLS(handshakeCtx, conn, host, protocols)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		var state tls.ConnectionState
+		if tlsConn != nil {
+			state = tlsConn.ConnectionState()
+		}
+		trace.TLSHandshakeDone(state, err)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake with %s: %w", addr, err)
+	}
+	return tlsConn, nil
+}
+
+// canonicalAddr returns the host:port of the request URL, adding the default
+// port for the scheme.
+func canonicalAddr(req *http.Request) string {
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(req.URL.Hostname(), port)
+}
+
+// contextError returns the context's error if it is done, so that timeouts
+// are reported as such.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// orderHeaders returns the headers in the order of the profile, with the
+// profile's casing, followed by the others sorted by name.
+func orderHeaders(headers map[string]string, profile *HeaderProfile) []HeaderField {
+	byKey := make(map[string]string, len(headers))
+	for name := range headers {
+		byKey[http.CanonicalHeaderKey(name)] = name
+	}
+	fields := make([]HeaderField, 0, len(headers))
+	if profile != nil {
+		for _, field := range profile.Headers {
+			key := http.CanonicalHeaderKey(field.Name)
+			if name, ok := byKey[key]; ok {
+				fields = append(fields, HeaderField{Name: field.Name, Value: headers[name]})
+				delete(byKey, key)
+			}
+		}
+	}
+	names := make([]string, 0, len(byKey))
+	for _, name := range byKey {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return http.CanonicalHeaderKey(names[i]) < http.CanonicalHeaderKey(names[j])
+	})
+	for _, name := range names {
+		fields = append(fields, HeaderField{Name: name, Value: headers[name]})
+	}
+	return fields
+}
+
+// writeOrderedRequest writes an HTTP/1.1 request. Headers listed in order are
+// written first, in that order and with the listed casing, followed by any
+// other headers of the request sorted by name. Only values present in
+// req.Header are written, so headers removed on redirect stay removed. Host
+// and Content-Length are written where listed, or else first and last.
+func writeOrderedRequest(w io.Writer, req *http.Request, order []HeaderField) error {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("unsupported protocol scheme %q", req.URL.Scheme)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	var contentLength string
+	switch {
+	case req.ContentLength > 0:
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	case req.Body != nil && req.Body != http.NoBody:
+		return errors.New("request body of unknown length is not supported")
+	case req.Method == http.MethodPost || req.Method == http.MethodPut || req.Method == http.MethodPatch:
+		contentLength = "0"
+	}
+
+	var lines []string
+	var invalid error
+	writeLine := func(name, value string) {
+		if name == "" || strings.ContainsAny(name, ": \t\r\n") || strings.ContainsAny(value, "\r\n") {
+			invalid = fmt.Errorf("invalid header: %q", name)
+		}
+		lines = append(lines, name+": "+value)
+	}
+	listed := make(map[string]bool, len(order))
+	for _, field := range order {
+		listed[http.CanonicalHeaderKey(field.Name)] = true
+	}
+	if !listed["Host"] {
+		writeLine("Host", host)
+	}
+	written := make(map[string]int, len(req.Header))
+	for _, field := range order {
+		key := http.CanonicalHeaderKey(field.Name)
+		switch key {
+		case "Host":
+			writeLine(field.Name, host)
+			continue
+		case "Content-Length":
+			if contentLength != "" {
+				writeLine(field.Name, contentLength)
+			}
+			continue
+		}
+		values := req.Header[key]
+		if written[key] >= len(values) {
+			continue
+		}
+		writeLine(field.Name, values[written[key]])
+		written[key]++
+	}
+	keys := make([]string, 0, len(req.Header))
+	for key := range req.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "Host" || key == "Content-Length" {
+			continue
+		}
+		for _, value := range req.Header[key][written[key]:] {
+			writeLine(key, value)
+		}
+	}
+	if contentLength != "" && !listed["Content-Length"] {
+		writeLine("Content-Length", contentLength)
+	}
+
+	if invalid != nil {
+		return invalid
+	}
+	if _, err := fmt.Fprintf(w, "%s %s HTTP/1.1\r\n%s\r\n\r\n", req.Method, req.URL.RequestURI(), strings.Join(lines, "\r\n")); err != nil {
+		return err
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		if _, err := io.Copy(w, req.Body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connBody closes the connection when the response body is closed.
+type connBody struct {
+	io.ReadCloser
+	once  sync.Once
+	close func()
+}
+
+func (b *connBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.close)
+	return err
+}
+
+// gzipBody decompresses a response body. The gzip header is read on the
+// first Read, so that empty bodies don't fail until they are read.
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = gzip.NewReader(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,268 @@
+package burrow
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawOrigin is an HTTP/1.1 server that records the bytes of each request it
+// receives and replies with the next of its responses.
+type rawOrigin struct {
+	listener  net.Listener
+	requests  chan string
+	responses []string
+}
+
+func newRawOrigin(t *testing.T, responses ...string) *rawOrigin {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	o := &rawOrigin{listener: listener, requests: make(chan string, 10), responses: responses}
+	go o.serve()
+	return o
+}
+
+func (o *rawOrigin) URL() string {
+	return "http://" + o.listener.Addr().String()
+}
+
+func (o *rawOrigin) Close() {
+	o.listener.Close()
+}
+
+func (o *rawOrigin) serve() {
+	for i := 0; ; i++ {
+		conn, err := o.listener.Accept()
+		if err != nil {
+			return
+		}
+		response := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+		if i < len(o.responses) {
+			response = o.responses[i]
+		}
+		o.requests <- readRawRequest(conn)
+		io.WriteString(conn, response)
+		conn.Close()
+	}
+}
+
+// readRawRequest reads the header and body of a request without parsing it.
+func readRawRequest(conn net.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	var head strings.Builder
+	contentLength := 0
+	for {
+		line, err := reader.ReadString('\n')
+		head.WriteString(line)
+		if err != nil || line == "\r\n" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			contentLength, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	body := make([]byte, contentLength)
+	io.ReadFull(reader, body)
+	return head.String() + string(body)
+}
+
+func TestGetHandler_OrderedHeaders(t *testing.T) {
+	origin := newRawOrigin(t)
+	defer origin.Close()
+
+	resp, err := GetHandler()(context.Background(), &Request{
+		URL:    origin.URL() + "/path?q=1",
+		Method: "POST",
+		OrderedHeaders: []HeaderField{
+			{"sec-ch-ua", `"Chromium";v="124"`},
+			{"User-Agent", "Mozilla/5.0"},
+			{"content-type", "text/plain"},
+			{"accept", "*/*"},
+			{"X-Multi", "1"},
+			{"x-multi", "2"},
+		},
+		Headers: map[string]string{"Ignored": "true"},
+		Body:    base64.StdEncoding.EncodeToString([]byte("hello")),
+		Cookies: "session=abc",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("ok")), resp.Body)
+
+	assert.Equal(t, "POST /path?q=1 HTTP/1.1\r\n"+
+		"Host: "+origin.listener.Addr().String()+"\r\n"+
+		"sec-ch-ua: \"Chromium\";v=\"124\"\r\n"+
+		"User-Agent: Mozilla/5.0\r\n"+
+		"content-type: text/plain\r\n"+
+		"accept: */*\r\n"+
+		"X-Multi: 1\r\n"+
+		"x-multi: 2\r\n"+
+		"Accept-Encoding: gzip\r\n"+
+		"Cookie: session=abc\r\n"+
+		"Content-Length: 5\r\n"+
+		"\r\n"+
+		"hello", <-origin.requests)
+}
+
+func TestGetHandler_OrderedHeadersPlacement(t *testing.T) {
+	origin := newRawOrigin(t)
+	defer origin.Close()
+
+	_, err := GetHandler()(context.Background(), &Request{
+		URL:    origin.URL(),
+		Method: "PUT",
+		OrderedHeaders: []HeaderField{
+			{"content-length", "999"},
+			{"user-agent", "curl/8.0"},
+			{"host", "example.com"},
+		},
+	})
+	require.NoError(t, err)
+	// Host is the request host and Content-Length the real length
+	assert.Equal(t, "PUT / HTTP/1.1\r\n"+
+		"content-length: 0\r\n"+
+		"user-agent: curl/8.0\r\n"+
+		"host: "+origin.listener.Addr().String()+"\r\n"+
+		"Accept-Encoding: gzip\r\n"+
+		"\r\n", <-origin.requests)
+}
+
+func TestGetHandler_OrderedHeadersRedirect(t *testing.T) {
+	origin := newRawOrigin(t, "HTTP/1.1 302 Found\r\nLocation: /next\r\nContent-Length: 0\r\n\r\n")
+	defer origin.Close()
+
+	resp, err := GetHandler()(context.Background(), &Request{
+		URL: origin.URL() + "/start",
+		OrderedHeaders: []HeaderField{
+			{"user-agent", "Mozilla/5.0"},
+			{"accept", "text/html"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	host := origin.listener.Addr().String()
+	assert.Equal(t, "GET /start HTTP/1.1\r\nHost: "+host+"\r\nuser-agent: Mozilla/5.0\r\naccept: text/html\r\n"+
+		"Accept-Encoding: gzip\r\n\r\n", <-origin.requests)
+	assert.Equal(t, "GET /next HTTP/1.1\r\nHost: "+host+"\r\nuser-agent: Mozilla/5.0\r\naccept: text/html\r\n"+
+		"Accept-Encoding: gzip\r\nReferer: "+origin.URL()+"/start\r\n\r\n", <-origin.requests)
+}
+
+func TestGetHandler_OrderedHeadersInvalid(t *testing.T) {
+	origin := newRawOrigin(t)
+	defer origin.Close()
+
+	for _, field := range []HeaderField{
+		{"X-Injected", "a\r\nEvil: 1"},
+		{"Bad Name", "value"},
+		{"", "value"},
+	} {
+		_, err := GetHandler()(context.Background(), &Request{
+			URL:            origin.URL(),
+			OrderedHeaders: []HeaderField{field},
+		})
+		assert.ErrorContains(t, err, "invalid header", field.Name)
+	}
+}
+
+func TestGetHandler_OrderedHeadersTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		// Accept but never respond
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	_, err = GetHandler()(context.Background(), &Request{
+		URL:            "http://" + listener.Addr().String(),
+		Timeout:        0.2,
+		OrderedHeaders: []HeaderField{{"accept", "*/*"}},
+	})
+	var proxyErr *ProxyError
+	require.ErrorAs(t, err, &proxyErr)
+	assert.Equal(t, ProxyErrTimeout, proxyErr.Type)
+}
+
+func TestClient_WithOrderedHeaders(t *testing.T) {
+	origin := newRawOrigin(t)
+	defer origin.Close()
+	function := httptest.NewServer(NewHTTPHandler(GetHandler()))
+	defer function.Close()
+
+	catalog := DefaultProfileCatalog()
+	client := NewClient(WithProxyURL(function.URL), WithOrderedHeaders(true))
+	client.Timeout = 10 * time.Second
+
+	ctx := UseHeaderProfile(context.Background(), catalog.Get("safari-macos"))
+	req, err := http.NewRequestWithContext(ctx, "GET", origin.URL(), nil)
+	require.NoError(t, err)
+	req.Header["x-api-key"] = []string{"secret"}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	var expected strings.Builder
+	fmt.Fprintf(&expected, "GET / HTTP/1.1\r\nHost: %s\r\n", origin.listener.Addr())
+	for _, field := range catalog.Get("safari-macos").Headers {
+		value := field.Value
+		if field.Name == "Accept" {
+			value = "application/json"
+		}
+		fmt.Fprintf(&expected, "%s: %s\r\n", field.Name, value)
+	}
+	expected.WriteString("x-api-key: secret\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.Equal(t, expected.String(), <-origin.requests)
+}
+
+func TestGetHandler_OrderedHeadersGzip(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("hello gzip"))
+	zw.Close()
+	gzipped := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", compressed.Len(), compressed.String())
+	origin := newRawOrigin(t, gzipped, gzipped)
+	defer origin.Close()
+
+	// Responses to the proxy's own Accept-Encoding are decompressed
+	resp, err := GetHandler()(context.Background(), &Request{
+		URL:            origin.URL(),
+		OrderedHeaders: []HeaderField{{"user-agent", "Mozilla/5.0"}},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, <-origin.requests, "Accept-Encoding: gzip\r\n")
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("hello gzip")), resp.Body)
+	assert.Empty(t, resp.Headers["Content-Encoding"])
+
+	// An encoding chosen by the client is sent as is and not decoded
+	resp, err = GetHandler()(context.Background(), &Request{
+		URL:            origin.URL(),
+		OrderedHeaders: []HeaderField{{"accept-encoding", "gzip, br"}},
+	})
+	require.NoError(t, err)
+	request := <-origin.requests
+	assert.Contains(t, request, "accept-encoding: gzip, br\r\n")
+	assert.NotContains(t, request, "Accept-Encoding")
+	assert.Equal(t, base64.StdEncoding.EncodeToString(compressed.Bytes()), resp.Body)
+	assert.Equal(t, "gzip", resp.Headers["Content-Encoding"])
+}
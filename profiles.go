@@ -0,0 +1,229 @@
+package burrow
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// HeaderField is a request header with its name as sent by a browser.
+type HeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HeaderProfile is a coherent set of request headers sent by a browser, in
+// the order the browser sends them. Accept-Encoding is left out: the proxy
+// asks for gzip itself and decompresses responses, as net/http does.
+type HeaderProfile struct {
+	Name    string
+	Headers []HeaderField
+}
+
+// Get returns the value of a header in the profile, or "" if it isn't set.
+func (p *HeaderProfile) Get(name string) string {
+	name = http.CanonicalHeaderKey(name)
+	for _, field := range p.Headers {
+		if http.CanonicalHeaderKey(field.Name) == name {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// apply adds the profile's headers to the request. Headers already set on
+// the request are kept, so callers can still set e.g. Accept for an API.
+func (p *HeaderProfile) apply(req *Request) {
+	if req.Headers == nil {
+		req.Headers = make(map[string]string, len(p.Headers))
+	}
+	set := make(map[string]bool, len(req.Headers))
+	for name := range req.Headers {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, field := range p.Headers {
+		if !set[http.CanonicalHeaderKey(field.Name)] {
+			req.Headers[field.Name] = field.Value
+		}
+	}
+}
+
+// chromeAccept is the Accept header Chromium sends for navigations
+const chromeAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"
+
+// chromiumProfile returns the headers sent by a Chromium based browser for a
+// top level navigation.
+func chromiumProfile(name, brands, platform, userAgent string) *HeaderProfile {
+	return &HeaderProfile{
+		Name: name,
+		Headers: []HeaderField{
+			{"sec-ch-ua", brands},
+			{"sec-ch-ua-mobile", "?0"},
+			{"sec-ch-ua-platform", platform},
+			{"Upgrade-Insecure-Requests", "1"},
+			{"User-Agent", userAgent},
+			{"Accept", chromeAccept},
+			{"Sec-Fetch-Site", "none"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-User", "?1"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Accept-Language", "en-US,en;q=0.9"},
+		},
+	}
+}
+
+// builtinProfiles returns the profiles in DefaultProfileCatalog.
+func builtinProfiles() []*HeaderProfile {
+	return []*HeaderProfile{
+		chromiumProfile("chrome-windows",
+			`"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`, `"Windows"`,
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"),
+		chromiumProfile("chrome-macos",
+			`"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`, `"macOS"`,
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"),
+		chromiumProfile("edge-windows",
+			`"Chromium";v="124", "Microsoft Edge";v="124", "Not-A.Brand";v="99"`, `"Windows"`,
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0"),
+		{
+			Name: "firefox-windows",
+			Headers: []HeaderField{
+				{"User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:125.0) Gecko/20100101 Firefox/125.0"},
+				{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"},
+				{"Accept-Language", "en-US,en;q=0.5"},
+				{"Upgrade-Insecure-Requests", "1"},
+				{"Sec-Fetch-Dest", "document"},
+				{"Sec-Fetch-Mode", "navigate"},
+				{"Sec-Fetch-Site", "none"},
+				{"Sec-Fetch-User", "?1"},
+			},
+		},
+		{
+			Name: "safari-macos",
+			Headers: []HeaderField{
+				{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+				{"Sec-Fetch-Site", "none"},
+				{"Sec-Fetch-Mode", "navigate"},
+				{"User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15"},
+				{"Accept-Language", "en-US,en;q=0.9"},
+				{"Sec-Fetch-Dest", "document"},
+			},
+		},
+	}
+}
+
+// ProfileCatalog is a set of header profiles to choose from. It is safe for
+// concurrent use.
+type ProfileCatalog struct {
+	mutex    sync.RWMutex
+	profiles []*HeaderProfile
+}
+
+// NewProfileCatalog creates a catalog containing the provided profiles.
+func NewProfileCatalog(profiles ...*HeaderProfile) *ProfileCatalog {
+	return &ProfileCatalog{profiles: profiles}
+}
+
+// DefaultProfileCatalog returns a catalog of recent desktop Chrome, Edge,
+// Firefox and Safari profiles. More profiles can be added to it.
+func DefaultProfileCatalog() *ProfileCatalog {
+	return NewProfileCatalog(builtinProfiles()...)
+}
+
+// Add adds profiles to the catalog, replacing any with the same name.
+func (c *ProfileCatalog) Add(profiles ...*HeaderProfile) *ProfileCatalog {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, profile := range profiles {
+		replaced := false
+		for i, existing := range c.profiles {
+			if existing.Name == profile.Name {
+				c.profiles[i], replaced = profile, true
+				break
+			}
+		}
+		if !replaced {
+			c.profiles = append(c.profiles, profile)
+		}
+	}
+	return c
+}
+
+// Get returns the named profile, or nil if it isn't in the catalog.
+func (c *ProfileCatalog) Get(name string) *HeaderProfile {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, profile := range c.profiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the profiles in the catalog.
+func (c *ProfileCatalog) Names() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	names := make([]string, len(c.profiles))
+	for i, profile := range c.profiles {
+		names[i] = profile.Name
+	}
+	return names
+}
+
+// Random returns a random profile, or nil if the catalog is empty.
+func (c *ProfileCatalog) Random() *HeaderProfile {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if len(c.profiles) == 0 {
+		return nil
+	}
+	return c.profiles[rand.Intn(len(c.profiles))]
+}
+
+// ProfileRotation controls how often a transport picks a new header profile.
+type ProfileRotation int
+
+const (
+	// RotatePerRequest picks a random profile for each request
+	RotatePerRequest ProfileRotation = iota
+	// StickyPerProxy picks a random profile once per proxy, so that each
+	// proxy looks like one browser
+	StickyPerProxy
+)
+
+// profileSelector picks the header profile for each request.
+type profileSelector struct {
+	catalog  *ProfileCatalog
+	rotation ProfileRotation
+	once     sync.Once
+	sticky   *HeaderProfile
+}
+
+func (s *profileSelector) profile(ctx context.Context) *HeaderProfile {
+	if profile := headerProfileFromContext(ctx); profile != nil {
+		return profile
+	}
+	if s == nil {
+		return nil
+	}
+	if s.rotation == StickyPerProxy {
+		s.once.Do(func() { s.sticky = s.catalog.Random() })
+		return s.sticky
+	}
+	return s.catalog.Random()
+}
+
+type headerProfileKey struct{}
+
+// UseHeaderProfile returns a context that sends requests made with it using
+// the profile, instead of one chosen by the transport.
+func UseHeaderProfile(ctx context.Context, profile *HeaderProfile) context.Context {
+	return context.WithValue(ctx, headerProfileKey{}, profile)
+}
+
+func headerProfileFromContext(ctx context.Context) *HeaderProfile {
+	profile, _ := ctx.Value(headerProfileKey{}).(*HeaderProfile)
+	return profile
+}
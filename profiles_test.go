@@ -0,0 +1,100 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultProfileCatalog(t *testing.T) {
+	catalog := DefaultProfileCatalog()
+	assert.Equal(t, []string{"chrome-windows", "chrome-macos", "edge-windows", "firefox-windows", "safari-macos"}, catalog.Names())
+
+	for _, name := range catalog.Names() {
+		profile := catalog.Get(name)
+		assert.NotEmpty(t, profile.Get("User-Agent"), name)
+		assert.NotEmpty(t, profile.Get("Accept"), name)
+		assert.NotEmpty(t, profile.Get("Accept-Language"), name)
+		assert.Empty(t, profile.Get("Accept-Encoding"), name)
+		// Client hints are only sent by Chromium and must match the user agent
+		if brands := profile.Get("Sec-Ch-Ua"); brands != "" {
+			assert.Contains(t, profile.Get("User-Agent"), "Chrome/124", name)
+			platform := strings.Trim(profile.Get("Sec-Ch-Ua-Platform"), `"`)
+			assert.Contains(t, profile.Get("User-Agent"), map[string]string{"Windows": "Windows NT", "macOS": "Mac OS X"}[platform], name)
+		}
+	}
+
+	custom := &HeaderProfile{Name: "chrome-windows", Headers: []HeaderField{{"User-Agent", "custom"}}}
+	catalog.Add(custom, &HeaderProfile{Name: "bot", Headers: []HeaderField{{"User-Agent", "bot/1.0"}}})
+	assert.Len(t, catalog.Names(), 6)
+	assert.Equal(t, "custom", catalog.Get("chrome-windows").Get("user-agent"))
+	assert.Nil(t, catalog.Get("missing"))
+	assert.Nil(t, NewProfileCatalog().Random())
+}
+
+func TestTransport_WithHeaderProfiles(t *testing.T) {
+	origin := newHeaderOrigin()
+	defer origin.Close()
+	function := httptest.NewServer(NewHTTPHandler(GetHandler()))
+	defer function.Close()
+
+	get := func(client *http.Client, req *http.Request) http.Header {
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var received http.Header
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&received))
+		return received
+	}
+	newRequest := func(ctx context.Context) *http.Request {
+		req, err := http.NewRequestWithContext(ctx, "GET", origin.URL, nil)
+		require.NoError(t, err)
+		return req
+	}
+
+	catalog := DefaultProfileCatalog()
+	sticky := NewClient(WithProxyURL(function.URL), WithHeaderProfiles(catalog, StickyPerProxy))
+	sticky.Timeout = 10 * time.Second
+	first := get(sticky, newRequest(context.Background()))
+	var profile *HeaderProfile
+	for _, name := range catalog.Names() {
+		if catalog.Get(name).Get("User-Agent") == first.Get("User-Agent") {
+			profile = catalog.Get(name)
+		}
+	}
+	require.NotNil(t, profile, first.Get("User-Agent"))
+	for _, field := range profile.Headers {
+		assert.Equal(t, field.Value, first.Get(field.Name), field.Name)
+	}
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, first.Get("User-Agent"), get(sticky, newRequest(context.Background())).Get("User-Agent"))
+	}
+
+	// Headers set by the caller take precedence
+	req := newRequest(context.Background())
+	req.Header.Set("Accept", "application/json")
+	received := get(sticky, req)
+	assert.Equal(t, "application/json", received.Get("Accept"))
+	assert.Equal(t, first.Get("User-Agent"), received.Get("User-Agent"))
+
+	// A profile can be chosen for a single request
+	firefox := catalog.Get("firefox-windows")
+	received = get(sticky, newRequest(UseHeaderProfile(context.Background(), firefox)))
+	assert.Equal(t, firefox.Get("User-Agent"), received.Get("User-Agent"))
+	assert.Empty(t, received.Get("Sec-Ch-Ua"))
+
+	rotating := NewClient(WithProxyURL(function.URL), WithHeaderProfiles(catalog, RotatePerRequest))
+	rotating.Timeout = 10 * time.Second
+	seen := map[string]bool{}
+	for i := 0; i < 40; i++ {
+		seen[get(rotating, newRequest(context.Background())).Get("User-Agent")] = true
+	}
+	assert.Greater(t, len(seen), 1)
+}
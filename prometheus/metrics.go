@@ -0,0 +1,106 @@
+// Package prometheus exposes the metrics of Burrow clients to Prometheus.
+package prometheus
+
+import (
+	"strconv"
+
+	"github.com/myzie/burrow"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ burrow.Metrics = &Metrics{}
+
+// Metrics is a burrow.Metrics implementation that exposes Prometheus
+// counters and histograms labeled by proxy URL.
+type Metrics struct {
+	requests         *prometheus.CounterVec
+	proxyErrors      *prometheus.CounterVec
+	retries          *prometheus.CounterVec
+	duration         *prometheus.HistogramVec
+	upstreamDuration *prometheus.HistogramVec
+	proxyOverhead    *prometheus.HistogramVec
+	bytesSent        *prometheus.CounterVec
+	bytesReceived    *prometheus.CounterVec
+}
+
+// NewMetrics creates a Metrics and registers its collectors with the provided
+// registerer.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	buckets := []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "burrow_requests_total",
+			Help: "Proxied requests by proxy and upstream status class.",
+		}, []string{"proxy", "status_class"}),
+		proxyErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "burrow_proxy_errors_total",
+			Help: "Failed proxy requests by proxy, error class and proxy error type.",
+		}, []string{"proxy", "class", "type"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "burrow_retries_total",
+			Help: "Retried requests by proxy and retryable status code.",
+		}, []string{"proxy", "status_code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "burrow_request_duration_seconds",
+			Help:    "Total time spent proxying a request, as seen by the client.",
+			Buckets: buckets,
+		}, []string{"proxy"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "burrow_upstream_duration_seconds",
+			Help:    "Time the proxy spent on the upstream request.",
+			Buckets: buckets,
+		}, []string{"proxy"}),
+		proxyOverhead: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "burrow_proxy_overhead_seconds",
+			Help:    "Time spent proxying a request outside of the upstream request.",
+			Buckets: buckets,
+		}, []string{"proxy"}),
+		bytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "burrow_sent_bytes_total",
+			Help: "Bytes sent to the proxy.",
+		}, []string{"proxy"}),
+		bytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "burrow_received_bytes_total",
+			Help: "Bytes received from the proxy.",
+		}, []string{"proxy"}),
+	}
+	for _, c := range []prometheus.Collector{
+		m.requests,
+		m.proxyErrors,
+		m.retries,
+		m.duration,
+		m.upstreamDuration,
+		m.proxyOverhead,
+		m.bytesSent,
+		m.bytesReceived,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ObserveRequest implements the burrow.Metrics interface.
+func (m *Metrics) ObserveRequest(obs *burrow.RequestObservation) {
+	proxy := obs.ProxyURL
+	m.requests.WithLabelValues(proxy, obs.StatusClass()).Inc()
+	m.duration.WithLabelValues(proxy).Observe(obs.Duration.Seconds())
+	m.bytesSent.WithLabelValues(proxy).Add(float64(obs.BytesSent))
+	m.bytesReceived.WithLabelValues(proxy).Add(float64(obs.BytesReceived))
+	if obs.Err != nil {
+		errType := ""
+		if obs.ErrorClass == burrow.ErrorClassProxy {
+			errType = strconv.Itoa(int(obs.ErrorType))
+		}
+		m.proxyErrors.WithLabelValues(proxy, string(obs.ErrorClass), errType).Inc()
+		return
+	}
+	m.upstreamDuration.WithLabelValues(proxy).Observe(obs.UpstreamDuration.Seconds())
+	m.proxyOverhead.WithLabelValues(proxy).Observe(obs.ProxyOverhead().Seconds())
+}
+
+// ObserveRetry implements the burrow.Metrics interface.
+func (m *Metrics) ObserveRetry(proxyURL string, statusCode int) {
+	m.retries.WithLabelValues(proxyURL, strconv.Itoa(statusCode)).Inc()
+}
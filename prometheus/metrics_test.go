@@ -0,0 +1,58 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/myzie/burrow"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStatusProxy(status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(burrow.Response{StatusCode: status})
+	}))
+}
+
+func TestMetrics(t *testing.T) {
+	unavailable := newStatusProxy(http.StatusServiceUnavailable)
+	defer unavailable.Close()
+	ok := newStatusProxy(http.StatusOK)
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	reg := prometheus.NewRegistry()
+	metrics, err := NewMetrics(reg)
+	require.NoError(t, err)
+
+	client := burrow.NewClient(
+		burrow.WithProxyURLs([]string{unavailable.URL, ok.URL, failing.URL}),
+		burrow.WithRetries(1),
+		burrow.WithMetrics(metrics),
+	)
+	resp, err := client.Get("https://example.com")
+	require.NoError(t, err)
+	resp.Body.Close()
+	_, err = client.Get("https://example.com")
+	require.Error(t, err)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues(unavailable.URL, "5xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues(ok.URL, "2xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues(failing.URL, "error")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.retries.WithLabelValues(unavailable.URL, "503")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.proxyErrors.WithLabelValues(failing.URL, "proxy", "0")))
+	assert.Greater(t, testutil.ToFloat64(metrics.bytesSent.WithLabelValues(ok.URL)), float64(0))
+	assert.Greater(t, testutil.ToFloat64(metrics.bytesReceived.WithLabelValues(ok.URL)), float64(0))
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.upstreamDuration))
+
+	_, err = NewMetrics(reg)
+	assert.Error(t, err, "duplicate registration should fail")
+}
@@ -0,0 +1,187 @@
+package burrow
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var _ http.RoundTripper = &RecordingTransport{}
+
+var _ http.RoundTripper = &ReplayTransport{}
+
+// ErrNoRecordedResponse is returned by a ReplayTransport when no recorded
+// response matches a request.
+var ErrNoRecordedResponse = errors.New("burrow: no recorded response")
+
+// Record is a single proxied request and its outcome, as written by a
+// RecordingTransport.
+type Record struct {
+	Time       time.Time   `json:"time"`
+	Request    *Request    `json:"request"`
+	Response   *Response   `json:"response,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	ProxyError *ProxyError `json:"proxy_error,omitempty"`
+	ProxyURL   string      `json:"proxy_url,omitempty"`
+	// RoundTrip is the total time taken by the client, in seconds
+	RoundTrip float64 `json:"round_trip"`
+}
+
+// key identifies requests that should be served the same recorded response.
+func (r *Record) key() string {
+	return r.Request.Method + " " + r.Request.URL + " " + r.Request.Body
+}
+
+// RecordingTransport is an http.RoundTripper that records each request and
+// response passing through it as a line of JSON.
+type RecordingTransport struct {
+	next   http.RoundTripper
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewRecordingTransport creates a RecordingTransport that sends requests using
+// next and writes records to w. To append to a file, open it with
+// os.O_APPEND|os.O_CREATE|os.O_WRONLY.
+func NewRecordingTransport(next http.RoundTripper, w io.Writer) *RecordingTransport {
+	return &RecordingTransport{next: next, writer: w}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	serReq, err := SerializeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize request: %w", err)
+	}
+	start := time.Now()
+	resp, rtErr := t.next.RoundTrip(req)
+	record := &Record{
+		Time:      start,
+		Request:   serReq,
+		RoundTrip: time.Since(start).Seconds(),
+	}
+	if rtErr != nil {
+		record.Error = rtErr.Error()
+		var proxyErr *ProxyError
+		if errors.As(rtErr, &proxyErr) {
+			record.ProxyError = proxyErr
+		}
+	} else {
+		record.Response, err = SerializeResponse(resp)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to serialize response: %w", err)
+		}
+		if md := ResponseMetadata(resp); md != nil {
+			record.ProxyURL = md.ProxyURL
+		}
+	}
+	if err := t.write(record); err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("failed to write record: %w", err)
+	}
+	return resp, rtErr
+}
+
+func (t *RecordingTransport) write(record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, err = t.writer.Write(append(line, '\n'))
+	return err
+}
+
+// ReadRecords reads all records from JSONL written by a RecordingTransport.
+func ReadRecords(r io.Reader) ([]*Record, error) {
+	var records []*Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid record on line %d: %w", line, err)
+		}
+		if record.Request == nil {
+			return nil, fmt.Errorf("invalid record on line %d: missing request", line)
+		}
+		records = append(records, &record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ReplayTransport is an http.RoundTripper that serves recorded responses
+// instead of sending requests. Requests are matched on method, URL and body.
+// When several records match, they are served in the order they were
+// recorded, and the last one is repeated once the others are exhausted.
+type ReplayTransport struct {
+	mutex   sync.Mutex
+	records map[string][]*Record
+}
+
+// NewReplayTransport creates a ReplayTransport serving the provided records.
+func NewReplayTransport(records []*Record) *ReplayTransport {
+	t := &ReplayTransport{records: map[string][]*Record{}}
+	for _, record := range records {
+		key := record.key()
+		t.records[key] = append(t.records[key], record)
+	}
+	return t
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	serReq, err := SerializeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize request: %w", err)
+	}
+	record := t.next((&Record{Request: serReq}).key())
+	if record == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoRecordedResponse, req.Method, req.URL)
+	}
+	if record.ProxyError != nil {
+		proxyErr := *record.ProxyError
+		return nil, &proxyErr
+	}
+	if record.Error != "" {
+		return nil, errors.New(record.Error)
+	}
+	if record.Response == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoRecordedResponse, req.Method, req.URL)
+	}
+	resp, err := DeserializeResponse(record.Response)
+	if err != nil {
+		return nil, err
+	}
+	md := newMetadata(record.ProxyURL, record.Response, time.Duration(record.RoundTrip*float64(time.Second)))
+	attachMetadata(req, resp, md)
+	return resp, nil
+}
+
+func (t *ReplayTransport) next(key string) *Record {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	records := t.records[key]
+	if len(records) == 0 {
+		return nil
+	}
+	if len(records) > 1 {
+		t.records[key] = records[1:]
+	}
+	return records[0]
+}
@@ -0,0 +1,85 @@
+package burrow
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var serReq Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&serReq))
+		if strings.HasSuffix(serReq.URL, "/missing") {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ProxyErrorf(ProxyErrTimeout, "http request timed out"))
+			return
+		}
+		json.NewEncoder(w).Encode(Response{
+			StatusCode: 201,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       base64.StdEncoding.EncodeToString([]byte("created " + serReq.Body)),
+			ProxyName:  "aws.lambda.us-east-1",
+			Duration:   0.25,
+		})
+	}))
+	defer mockProxy.Close()
+
+	var buf bytes.Buffer
+	recorder := NewRecordingTransport(NewTransportWithOptions(WithProxyURL(mockProxy.URL)), &buf)
+	client := &http.Client{Transport: recorder}
+
+	resp, err := client.Post("https://example.com/items", "text/plain", strings.NewReader("item"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "created aXRlbQ==", string(body))
+
+	_, err = client.Get("https://example.com/missing")
+	require.Error(t, err)
+
+	records, err := ReadRecords(&buf)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "POST", records[0].Request.Method)
+	assert.Equal(t, "aws.lambda.us-east-1", records[0].Response.ProxyName)
+	assert.Equal(t, mockProxy.URL, records[0].ProxyURL)
+	require.NotNil(t, records[1].ProxyError)
+	assert.Equal(t, ProxyErrTimeout, records[1].ProxyError.Type)
+
+	replay := &http.Client{Transport: NewReplayTransport(records)}
+
+	resp, err = replay.Post("https://example.com/items", "text/plain", strings.NewReader("item"))
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "created aXRlbQ==", string(body))
+	md := ResponseMetadata(resp)
+	require.NotNil(t, md)
+	assert.Equal(t, "aws.lambda.us-east-1", md.ProxyName)
+
+	_, err = replay.Get("https://example.com/missing")
+	var proxyErr *ProxyError
+	require.True(t, errors.As(err, &proxyErr))
+	assert.Equal(t, ProxyErrTimeout, proxyErr.Type)
+
+	_, err = replay.Post("https://example.com/items", "text/plain", strings.NewReader("other"))
+	assert.ErrorIs(t, err, ErrNoRecordedResponse)
+}
+
+func TestReadRecords_Invalid(t *testing.T) {
+	_, err := ReadRecords(strings.NewReader("{}\n"))
+	assert.EqualError(t, err, "invalid record on line 1: missing request")
+}
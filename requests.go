@@ -10,14 +10,23 @@ import (
 
 // Request represents an http request in a format that can be easily serialized
 type Request struct {
-	URL                 string            `json:"url"`
-	Method              string            `json:"method,omitempty"`
-	Headers             map[string]string `json:"headers,omitempty"`
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// OrderedHeaders, if set, replaces Headers. The proxy then writes the
+	// request itself over HTTP/1.1, with headers in this order and casing.
+	OrderedHeaders      []HeaderField     `json:"ordered_headers,omitempty"`
 	Body                string            `json:"body,omitempty"`
 	Cookies             string            `json:"cookies,omitempty"`
 	Timeout             float64           `json:"timeout,omitempty"`
 	MaxResponseBytes    int64             `json:"max_response_bytes,omitempty"`
 	AllowedContentTypes []string          `json:"allowed_content_types,omitempty"`
+	Diagnostics         bool              `json:"diagnostics,omitempty"`
+	TraceContext        map[string]string `json:"trace_context,omitempty"`
+	Retire              bool              `json:"retire,omitempty"`
+	// TLSProfile names the TLS profile used to connect upstream, e.g.
+	// "chrome". See TLSProfiles.
+	TLSProfile string `json:"tls_profile,omitempty"`
 }
 
 // Response represents an http response in a format that can be easily deserialized
@@ -28,6 +37,10 @@ type Response struct {
 	ClientDetails *ClientDetails    `json:"client_details,omitempty"`
 	Duration      float64           `json:"duration,omitempty"`
 	ProxyName     string            `json:"proxy_name,omitempty"`
+	Diagnostics   *Diagnostics      `json:"diagnostics,omitempty"`
+	Spans         []SpanTiming      `json:"spans,omitempty"`
+	EgressIP      string            `json:"egress_ip,omitempty"`
+	EnvironmentID string            `json:"environment_id,omitempty"`
 }
 
 // ClientDetails represents the details of the client that made the request
@@ -36,9 +49,16 @@ type ClientDetails struct {
 	UserAgent string `json:"user_agent"`
 }
 
-func SerializeRequest(req *http.Request) (*Request, error) {
+// SerializeRequest converts an http.Request into a Request. Headers named in
+// stripHeaders are not included. The request body is read and replaced so
+// that it may be read again.
+func SerializeRequest(req *http.Request, stripHeaders ...string) (*Request, error) {
+	strip := canonicalHeaderSet(stripHeaders)
 	headers := make(map[string]string)
 	for k, v := range req.Header {
+		if strip[http.CanonicalHeaderKey(k)] {
+			continue
+		}
 		headers[k] = v[0]
 	}
 	var encodedBody string
@@ -68,12 +88,59 @@ func DeserializeResponse(serResp *Response) (*http.Response, error) {
 		}
 	}
 	resp := &http.Response{
-		StatusCode: serResp.StatusCode,
-		Header:     make(http.Header),
-		Body:       io.NopCloser(bytes.NewBuffer(decodedBody)),
+		StatusCode:    serResp.StatusCode,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(bytes.NewBuffer(decodedBody)),
+		ContentLength: int64(len(decodedBody)),
 	}
 	for k, v := range serResp.Headers {
 		resp.Header.Set(k, v)
 	}
+	if diag := serResp.Diagnostics; diag != nil {
+		if major, minor, ok := http.ParseHTTPVersion(diag.Protocol); ok {
+			resp.Proto = diag.Protocol
+			resp.ProtoMajor = major
+			resp.ProtoMinor = minor
+		}
+		if diag.TLS != nil {
+			resp.TLS = diag.TLS.ConnectionState()
+		}
+	}
 	return resp, nil
 }
+
+// SerializeResponse converts an http.Response into a Response. The response
+// body is read and replaced so that it may still be read by the caller. Proxy
+// metadata attached to the response, if any, is included.
+func SerializeResponse(resp *http.Response) (*Response, error) {
+	headers := make(map[string]string)
+	for k, v := range resp.Header {
+		headers[k] = v[0]
+	}
+	var encodedBody string
+	if resp.Body != nil {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewBuffer(body))
+		if len(body) > 0 {
+			encodedBody = base64.StdEncoding.EncodeToString(body)
+		}
+	}
+	serResp := &Response{
+		StatusCode: resp.StatusCode,
+		Headers:    headers,
+		Body:       encodedBody,
+	}
+	if md := ResponseMetadata(resp); md != nil {
+		serResp.ProxyName = md.ProxyName
+		serResp.Duration = md.Duration.Seconds()
+		serResp.ClientDetails = md.ClientDetails
+		serResp.Diagnostics = md.Diagnostics
+		serResp.EgressIP = md.EgressIP
+		serResp.EnvironmentID = md.EnvironmentID
+	}
+	return serResp, nil
+}
@@ -73,13 +73,47 @@ func GetHandler(c ...*http.Client) Handler {
 		if err != nil {
 			return nil, ProxyErrorf(ProxyErrBadRequest, "failed to create http request: %v", err)
 		}
-		for k, v := range req.Headers {
-			httpReq.Header.Set(k, v)
+		// TLS profiles and ordered headers use their own transports, with the
+		// same redirect policy
+		var transport http.RoundTripper
+		ordered := defaultOrderedHeaderTransport
+		if req.TLSProfile != "" && req.TLSProfile != TLSProfileGo {
+			profile, ok := lookupTLSProfile(req.TLSProfile)
+			if !ok {
+				return nil, ProxyErrorf(ProxyErrBadRequest, "unknown tls profile: %s", req.TLSProfile)
+			}
+			transport, ordered = profile.transport, profile.ordered
+		}
+		if len(req.OrderedHeaders) > 0 {
+			for _, field := range req.OrderedHeaders {
+				httpReq.Header.Add(field.Name, field.Value)
+			}
+			httpReq = httpReq.WithContext(withHeaderOrder(httpReq.Context(), req.OrderedHeaders))
+			transport = ordered
+		} else {
+			for k, v := range req.Headers {
+				httpReq.Header.Set(k, v)
+			}
 		}
 		if req.Cookies != "" {
 			httpReq.Header.Add("Cookie", req.Cookies)
 		}
-		resp, err := client.Do(httpReq)
+		var trace *diagnosticsTrace
+		var tracer *upstreamTracer
+		if req.Diagnostics || len(req.TraceContext) > 0 {
+			trace = &diagnosticsTrace{}
+			httpReq = httpReq.WithContext(trace.withContext(httpReq.Context()))
+		}
+		if len(req.TraceContext) > 0 {
+			tracer = newUpstreamTracer(ctx, start, trace)
+		}
+		doer := client
+		if transport != nil {
+			custom := *client
+			custom.Transport = transport
+			doer = &custom
+		}
+		resp, err := doer.Do(httpReq)
 		if err != nil {
 			if isTimeoutError(err) {
 				return nil, ProxyErrorf(ProxyErrTimeout, "http request timed out")
@@ -100,7 +134,11 @@ func GetHandler(c ...*http.Client) Handler {
 		}
 		// Add 1 so that we can detect if the body was truncated
 		limitReader := io.LimitReader(resp.Body, maxSize+1)
+		readStart := time.Now()
 		body, err := io.ReadAll(limitReader)
+		if tracer != nil {
+			tracer.record("read_body", readStart, time.Now())
+		}
 		if err != nil {
 			if isTimeoutError(err) {
 				return nil, ProxyErrorf(ProxyErrTimeout, "response body read timed out")
@@ -118,12 +156,19 @@ func GetHandler(c ...*http.Client) Handler {
 		for k, v := range resp.Header {
 			headers[k] = v[0]
 		}
-		return &Response{
+		response := &Response{
 			StatusCode: resp.StatusCode,
 			Headers:    headers,
 			Body:       encodedBody,
 			Duration:   time.Since(start).Seconds(),
-		}, nil
+		}
+		if req.Diagnostics {
+			response.Diagnostics = trace.diagnostics(resp)
+		}
+		if tracer != nil {
+			response.Spans = tracer.finish()
+		}
+		return response, nil
 	}
 }
 
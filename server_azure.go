@@ -0,0 +1,136 @@
+package burrow
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// AzureHandler serves a Handler as an Azure Functions custom handler. The
+// Functions host posts each invocation to the handler as JSON containing the
+// HTTP trigger data and expects the HTTP output binding in the reply. The
+// bindings in function.json must be named "req" and "res":
+//
+//	{
+//	  "bindings": [
+//	    {"type": "httpTrigger", "direction": "in", "name": "req", "methods": ["post"]},
+//	    {"type": "http", "direction": "out", "name": "res"}
+//	  ]
+//	}
+//
+// With enableForwardingHttpRequest set in host.json the host forwards the HTTP
+// request unchanged instead, and an HTTPHandler should be used.
+type AzureHandler struct {
+	payloadServer
+}
+
+// azureInvocation is the request sent by the Functions host to a custom
+// handler.
+type azureInvocation struct {
+	Data struct {
+		Req azureHTTPRequest `json:"req"`
+	} `json:"Data"`
+}
+
+type azureHTTPRequest struct {
+	URL     string              `json:"Url"`
+	Method  string              `json:"Method"`
+	Headers map[string][]string `json:"Headers"`
+	// Body is a JSON string, or the parsed body for JSON content types
+	Body json.RawMessage `json:"Body"`
+}
+
+// azureInvocationResult is the reply expected by the Functions host.
+type azureInvocationResult struct {
+	Outputs struct {
+		Res azureHTTPResponse `json:"res"`
+	} `json:"Outputs"`
+	Logs        []string `json:"Logs"`
+	ReturnValue any      `json:"ReturnValue"`
+}
+
+type azureHTTPResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Body       string            `json:"body"`
+	Headers    map[string]string `json:"headers"`
+}
+
+// NewAzureHandler creates an AzureHandler that proxies requests with handler,
+// e.g. one returned by GetHandler.
+func NewAzureHandler(handler Handler) *AzureHandler {
+	return &AzureHandler{payloadServer{handler: handler}}
+}
+
+// WithProxyName sets the proxy name reported in responses, e.g.
+// "azure.functions.eastus"
+func (s *AzureHandler) WithProxyName(name string) *AzureHandler {
+	s.proxyName = name
+	return s
+}
+
+// WithEncryption requires payloads to be encrypted with a key in the keyring,
+// as sent by a backend created with NewEncryptedBackend
+func (s *AzureHandler) WithEncryption(keyring *Keyring) *AzureHandler {
+	s.keyring = keyring
+	return s
+}
+
+// ServeHTTP implements the http.Handler interface
+func (s *AzureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var result azureInvocationResult
+	var invocation azureInvocation
+	payload, err := readPayload(r.Body)
+	if err != nil {
+		result.Outputs.Res.StatusCode, result.Outputs.Res.Body = errorReplyString(http.StatusRequestEntityTooLarge, err)
+	} else if err := json.Unmarshal(payload, &invocation); err != nil {
+		result.Outputs.Res.StatusCode, result.Outputs.Res.Body = errorReplyString(http.StatusBadRequest, errors.New("invalid invocation request (expected json)"))
+	} else {
+		req := invocation.Data.Req
+		var statusCode int
+		var body []byte
+		if !strings.EqualFold(req.Method, http.MethodPost) {
+			statusCode, body = errorReply(http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		} else {
+			statusCode, body = s.serve(r.Context(), req.body(), &ClientDetails{
+				SourceIP:  req.sourceIP(),
+				UserAgent: req.header("User-Agent"),
+			})
+		}
+		result.Outputs.Res.StatusCode, result.Outputs.Res.Body = statusCode, string(body)
+	}
+	result.Outputs.Res.Headers = map[string]string{"Content-Type": "application/json"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func errorReplyString(statusCode int, err error) (int, string) {
+	statusCode, body := errorReply(statusCode, err)
+	return statusCode, string(body)
+}
+
+// body returns the HTTP request body, which the host sends as a JSON string
+// or, for JSON content types, as the JSON value itself.
+func (r azureHTTPRequest) body() []byte {
+	var body string
+	if err := json.Unmarshal(r.Body, &body); err == nil {
+		return []byte(body)
+	}
+	return r.Body
+}
+
+func (r azureHTTPRequest) header(name string) string {
+	for key, values := range r.Headers {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// sourceIP returns the client address from X-Forwarded-For, which the
+// Functions front end sets to the client IP and port.
+func (r azureHTTPRequest) sourceIP() string {
+	forwarded, _, _ := strings.Cut(r.header("X-Forwarded-For"), ",")
+	return remoteIP(strings.TrimSpace(forwarded))
+}
@@ -0,0 +1,169 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// defaultMaxPayloadBytes limits the size of payloads accepted by the server
+// adapters. Batches of requests with bodies can be large.
+var defaultMaxPayloadBytes = int64(32 * 1024 * 1024) // 32MB
+
+// HTTPHandler serves a Handler over HTTP, so that it can run as a Google Cloud
+// Function, a Cloud Run service, an Azure Function with HTTP forwarding, or
+// any other HTTP server. It accepts the payloads sent by an HTTPBackend,
+// including batches, and replies like the Lambda function. Requests to retire
+// the execution environment are ignored.
+type HTTPHandler struct {
+	payloadServer
+}
+
+// NewHTTPHandler creates an HTTPHandler that proxies requests with handler,
+// e.g. one returned by GetHandler.
+func NewHTTPHandler(handler Handler) *HTTPHandler {
+	return &HTTPHandler{payloadServer{handler: handler}}
+}
+
+// WithProxyName sets the proxy name reported in responses, e.g.
+// "gcp.functions.us-central1"
+func (s *HTTPHandler) WithProxyName(name string) *HTTPHandler {
+	s.proxyName = name
+	return s
+}
+
+// WithEncryption requires payloads to be encrypted with a key in the keyring,
+// as sent by a backend created with NewEncryptedBackend
+func (s *HTTPHandler) WithEncryption(keyring *Keyring) *HTTPHandler {
+	s.keyring = keyring
+	return s
+}
+
+// ServeHTTP implements the http.Handler interface
+func (s *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var statusCode int
+	var body []byte
+	if r.Method != http.MethodPost {
+		statusCode, body = errorReply(http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	} else if payload, err := readPayload(r.Body); err != nil {
+		statusCode, body = errorReply(http.StatusRequestEntityTooLarge, err)
+	} else {
+		statusCode, body = s.serve(r.Context(), payload, &ClientDetails{
+			SourceIP:  remoteIP(r.RemoteAddr),
+			UserAgent: r.UserAgent(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+func readPayload(r io.Reader) ([]byte, error) {
+	payload, err := io.ReadAll(io.LimitReader(r, defaultMaxPayloadBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if int64(len(payload)) > defaultMaxPayloadBytes {
+		return nil, fmt.Errorf("request body exceeded maximum size: %d", defaultMaxPayloadBytes)
+	}
+	return payload, nil
+}
+
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// payloadServer handles the payloads received by the server adapters.
+type payloadServer struct {
+	handler   Handler
+	proxyName string
+	keyring   *Keyring
+}
+
+// serve proxies the request in the payload and returns the status code and
+// body of the reply expected by a Backend. If a keyring is set the payload
+// must be an Envelope, and the reply is encrypted with the same key.
+func (s *payloadServer) serve(ctx context.Context, payload []byte, client *ClientDetails) (int, []byte) {
+	if s.keyring == nil {
+		return servePayload(ctx, s.handler, s.proxyName, payload, client)
+	}
+	payload, request, err := s.keyring.OpenRequest(payload)
+	if err != nil {
+		return errorReply(http.StatusBadRequest, err)
+	}
+	statusCode, body := servePayload(ctx, s.handler, s.proxyName, payload, client)
+	sealed, err := s.keyring.SealReply(request, statusCode, body)
+	if err != nil {
+		return errorReply(http.StatusInternalServerError, err)
+	}
+	return http.StatusOK, sealed
+}
+
+// servePayload proxies the JSON Request or BatchRequest in the payload and
+// returns the status code and JSON body of the reply.
+func servePayload(ctx context.Context, handler Handler, proxyName string, payload []byte, client *ClientDetails) (int, []byte) {
+	if IsBatchPayload(payload) {
+		var batch BatchRequest
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			return errorReply(http.StatusBadRequest, errors.New("invalid batch request body (expected json)"))
+		}
+		response, err := handler.HandleBatch(ctx, &batch)
+		if err != nil {
+			return errorReply(http.StatusInternalServerError, err)
+		}
+		for _, result := range response.Results {
+			if result.Response != nil {
+				annotateResponse(result.Response, proxyName, client)
+			}
+		}
+		return jsonReply(response)
+	}
+	var req Request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorReply(http.StatusBadRequest, errors.New("invalid request body (expected json)"))
+	}
+	response, err := handler(ctx, &req)
+	if err != nil {
+		return errorReply(http.StatusInternalServerError, err)
+	}
+	annotateResponse(response, proxyName, client)
+	return jsonReply(response)
+}
+
+func annotateResponse(response *Response, proxyName string, client *ClientDetails) {
+	response.ClientDetails = client
+	response.ProxyName = proxyName
+	response.EnvironmentID = EnvironmentID()
+}
+
+func jsonReply(v any) (int, []byte) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return errorReply(http.StatusInternalServerError, err)
+	}
+	return http.StatusOK, body
+}
+
+// errorReply returns the reply for an error. Proxy errors are returned as is,
+// with status 400 for bad requests, and other errors as a message.
+func errorReply(statusCode int, err error) (int, []byte) {
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		statusCode = http.StatusInternalServerError
+		if proxyErr.Type == ProxyErrBadRequest {
+			statusCode = http.StatusBadRequest
+		}
+		body, _ := json.Marshal(proxyErr)
+		return statusCode, body
+	}
+	body, _ := json.Marshal(map[string]string{"message": err.Error()})
+	return statusCode, body
+}
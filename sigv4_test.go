@@ -0,0 +1,267 @@
+package burrow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigV4Signer(t *testing.T) {
+	// Expected signatures were generated with the AWS SDK for Go v2 signer
+	tests := []struct {
+		token    string
+		expected string
+	}{
+		{
+			expected: "AWS4-HMAC-SHA256 Credential=AKID/20240501/us-east-1/lambda/aws4_request, " +
+				"SignedHeaders=content-length;content-type;host;x-amz-date, " +
+				"Signature=deb1117649ae53311a418f6c36e5778e9dfbc9824be5fa46d7968f8149b60842",
+		},
+		{
+			token: "TOKEN",
+			expected: "AWS4-HMAC-SHA256 Credential=AKID/20240501/us-east-1/lambda/aws4_request, " +
+				"SignedHeaders=content-length;content-type;host;x-amz-date;x-amz-security-token, " +
+				"Signature=43a8dd5e600c226bfc1d06254b0a8077ac3bcb94001b84ca0568a0f1f3b76b70",
+		},
+	}
+	for _, tt := range tests {
+		body := []byte(`{"url":"https://example.com"}`)
+		req, err := http.NewRequest("POST", LambdaInvokeURL("us-east-1", "burrow:live"), bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		signer := newSigV4Signer(StaticCredentials("AKID", "SECRET", tt.token), "us-east-1", lambdaService)
+		signer.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+		require.NoError(t, signer.sign(context.Background(), req, body))
+
+		assert.Equal(t, tt.expected, req.Header.Get("Authorization"))
+		assert.Equal(t, "20240501T120000Z", req.Header.Get("X-Amz-Date"))
+		assert.Equal(t, tt.token, req.Header.Get("X-Amz-Security-Token"))
+	}
+}
+
+var authorizationPattern = regexp.MustCompile(
+	`^AWS4-HMAC-SHA256 Credential=([^/]+)/(\d{8})/([^/]+)/([^/]+)/aws4_request, SignedHeaders=([^,]+), Signature=([0-9a-f]{64})$`)
+
+// verifySigV4 checks the signature of a request received by a stand-in AWS
+// endpoint, as AWS would.
+func verifySigV4(r *http.Request, body []byte, secrets map[string]string) error {
+	match := authorizationPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	if match == nil {
+		return fmt.Errorf("missing or malformed authorization header")
+	}
+	accessKey, date, region, service, signedHeaders, signature := match[1], match[2], match[3], match[4], match[5], match[6]
+	secret, ok := secrets[accessKey]
+	if !ok {
+		return fmt.Errorf("unknown access key: %s", accessKey)
+	}
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Date"), date) {
+		return fmt.Errorf("date mismatch")
+	}
+	req := r.Clone(r.Context())
+	req.URL.Host = r.Host
+	canonicalRequest, expectedHeaders := sigV4CanonicalRequest(req, body)
+	if signedHeaders != expectedHeaders {
+		return fmt.Errorf("signed headers mismatch: %s", signedHeaders)
+	}
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		r.Header.Get("X-Amz-Date"),
+		strings.Join([]string{date, region, service, "aws4_request"}, "/"),
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	if fmt.Sprintf("%x", hmacSHA256(key, stringToSign)) != signature {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// newLambdaAPI returns a stand-in for the Lambda Invoke API that verifies
+// request signatures and invokes the handler with the Function URL event.
+func newLambdaAPI(t *testing.T, handler Handler) *httptest.Server {
+	secrets := map[string]string{"AKID": "SECRET"}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if err := verifySigV4(r, body, secrets); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"Message": err.Error()})
+			return
+		}
+		if r.URL.EscapedPath() != "/2015-03-31/functions/burrow%3Alive/invocations" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"Message": "function not found"})
+			return
+		}
+		var event lambdaInvokeEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		var req Request
+		require.NoError(t, json.Unmarshal([]byte(event.Body), &req))
+		if req.URL == "https://example.com/panic" {
+			w.Header().Set("X-Amz-Function-Error", "Unhandled")
+			json.NewEncoder(w).Encode(lambdaFunctionError{ErrorMessage: "boom", ErrorType: "Runtime.ExitError"})
+			return
+		}
+		resp, err := handler(r.Context(), &req)
+		result := lambdaInvokeResult{StatusCode: 200}
+		if err != nil {
+			result.StatusCode = 400
+			body, _ := json.Marshal(err)
+			result.Body = string(body)
+		} else {
+			resp.ProxyName = "aws.lambda.us-east-1"
+			body, _ := json.Marshal(resp)
+			result.Body = string(body)
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+}
+
+func TestLambdaTransport(t *testing.T) {
+	handler := Handler(func(ctx context.Context, req *Request) (*Response, error) {
+		if req.URL == "https://example.com/bad" {
+			return nil, ProxyErrorf(ProxyErrBadRequest, "bad request")
+		}
+		return &Response{StatusCode: 200, Body: "b2s="}, nil
+	})
+	lambdaAPI := newLambdaAPI(t, handler)
+	defer lambdaAPI.Close()
+
+	transport := NewTransportWithBackend(
+		NewLambdaBackend("burrow:live", "us-east-1", StaticCredentials("AKID", "SECRET", "")).WithEndpoint(lambdaAPI.URL))
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	resp, err := client.Post("https://example.com", "text/plain", strings.NewReader("payload"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, "aws.lambda.us-east-1", ResponseMetadata(resp).ProxyName)
+
+	_, err = client.Get("https://example.com/bad")
+	assert.EqualError(t, err, `Get "https://example.com/bad": proxy error [1] bad request`)
+
+	_, err = client.Get("https://example.com/panic")
+	assert.EqualError(t, err, `Get "https://example.com/panic": proxy error [0] lambda function error: Runtime.ExitError: boom`)
+
+	badCredentials := NewTransportWithBackend(
+		NewLambdaBackend("burrow:live", "us-east-1", StaticCredentials("AKID", "WRONG", "")).WithEndpoint(lambdaAPI.URL))
+	_, err = (&http.Client{Transport: badCredentials}).Get("https://example.com")
+	assert.EqualError(t, err, `Get "https://example.com": proxy error [0] lambda invoke returned status 403: signature mismatch`)
+}
+
+func TestTransport_WithSigV4(t *testing.T) {
+	secrets := map[string]string{"AKID": "SECRET"}
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if err := verifySigV4(r, body, secrets); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Forbidden"})
+			return
+		}
+		json.NewEncoder(w).Encode(Response{StatusCode: 204})
+	}))
+	defer mockProxy.Close()
+
+	transport := NewTransport(mockProxy.URL, "POST").WithSigV4("us-east-1", StaticCredentials("AKID", "SECRET", "TOKEN"))
+	resp, err := (&http.Client{Transport: transport}).Get("https://example.com")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 204, resp.StatusCode)
+
+	unsigned := NewTransport(mockProxy.URL, "POST")
+	_, err = (&http.Client{Transport: unsigned}).Get("https://example.com")
+	assert.EqualError(t, err, `Get "https://example.com": proxy error [0] Forbidden`)
+
+	// The region can't be taken from a URL that is not a Function URL
+	noRegion := NewTransport(mockProxy.URL, "POST").WithSigV4("", StaticCredentials("AKID", "SECRET", ""))
+	_, err = (&http.Client{Transport: noRegion}).Get("https://example.com")
+	assert.ErrorContains(t, err, "sigv4 requires a region for proxy url "+mockProxy.URL)
+}
+
+func TestUnwrapInvokeResult_APIErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		want   ErrorCode
+	}{
+		{http.StatusTooManyRequests, ProxyErrThrottled},
+		{http.StatusInternalServerError, ProxyErrUnavailable},
+		{http.StatusServiceUnavailable, ProxyErrUnavailable},
+		{http.StatusForbidden, ProxyErrUnknown},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		_, _, err := unwrapInvokeResult(resp, []byte(`{"Message":"failed"}`))
+		var proxyErr *ProxyError
+		require.ErrorAs(t, err, &proxyErr)
+		assert.Equal(t, tt.want, proxyErr.Type, tt.status)
+	}
+}
+
+func TestSharedCredentials(t *testing.T) {
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(credentialsFile, []byte(`
+[default]
+aws_access_key_id = DEFAULTKEY
+aws_secret_access_key = defaultsecret
+
+[work]
+aws_access_key_id=WORKKEY
+aws_secret_access_key=worksecret
+aws_session_token=worktoken
+`), 0o600))
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+[profile other]
+region = eu-west-1
+aws_access_key_id = OTHERKEY
+aws_secret_access_key = othersecret
+`), 0o600))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	ctx := context.Background()
+
+	creds, err := DefaultCredentials().Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{AccessKeyID: "DEFAULTKEY", SecretAccessKey: "defaultsecret"}, creds)
+
+	creds, err = SharedCredentials("work").Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{AccessKeyID: "WORKKEY", SecretAccessKey: "worksecret", SessionToken: "worktoken"}, creds)
+
+	creds, err = SharedCredentials("other").Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "OTHERKEY", creds.AccessKeyID)
+
+	_, err = SharedCredentials("missing").Retrieve(ctx)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	creds, err = DefaultCredentials().Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ENVKEY", creds.AccessKeyID)
+}
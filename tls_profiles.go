@@ -0,0 +1,246 @@
+package burrow
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Names of the TLS profiles, used in Request.TLSProfile. The browser
+// profiles are registered by importing github.com/myzie/burrow/tlsprofiles
+// in the proxy.
+const (
+	// TLSProfileGo is the ClientHello of crypto/tls, which is also used
+	// when no profile is set
+	TLSProfileGo = "go"
+	// TLSProfileChrome mimics the ClientHello of recent Chrome versions
+	TLSProfileChrome = "chrome"
+	// TLSProfileFirefox mimics the ClientHello of recent Firefox versions
+	TLSProfileFirefox = "firefox"
+	// TLSProfileSafari mimics the ClientHello of Safari on macOS
+	TLSProfileSafari = "safari"
+)
+
+// httpProtocols are the ALPN protocols offered by a profileTransport, which
+// speaks whichever of them the server selects.
+var httpProtocols = []string{"h2", "http/1.1"}
+
+// TLSConn is a TLS client connection.
+type TLSConn interface {
+	net.Conn
+	ConnectionState() tls.ConnectionState
+}
+
+// TLSDialer performs the TLS handshake for upstream connections, so it
+// controls the ClientHello, and with it the TLS fingerprint (JA3/JA4), seen
+// by servers.
+type TLSDialer interface {
+	// DialTLS performs a TLS client handshake over conn with serverName,
+	// offering protocols with ALPN, e.g. "h2" and "http/1.1"
+	DialTLS(ctx context.Context, conn net.Conn, serverName string, protocols []string) (TLSConn, error)
+}
+
+// NewGoTLSDialer creates a TLSDialer that uses crypto/tls. The config may be
+// nil. Its ServerName and NextProtos are set for each connection.
+func NewGoTLSDialer(config *tls.Config) TLSDialer {
+	return &goTLSDialer{config: config}
+}
+
+type goTLSDialer struct {
+	config *tls.Config
+}
+
+func (d *goTLSDialer) DialTLS(ctx context.Context, conn net.Conn, serverName string, protocols []string) (TLSConn, error) {
+	config := &tls.Config{}
+	if d.config != nil {
+		config = d.config.Clone()
+	}
+	config.ServerName = serverName
+	config.NextProtos = protocols
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// tlsProfile holds the transports used for requests with a TLS profile.
+type tlsProfile struct {
+	transport *profileTransport
+	ordered   *orderedHeaderTransport
+}
+
+func newTLSProfile(tlsDialer TLSDialer) *tlsProfile {
+	ordered := newOrderedHeaderTransport(tlsDialer)
+	t := &profileTransport{http2Hosts: map[string]bool{}}
+	t.http1 = &http.Transport{
+		DialContext: ordered.dialer.DialContext,
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := ordered.dialTLS(ctx, network, addr, nil, httpProtocols)
+			if err != nil {
+				return nil, err
+			}
+			if conn.ConnectionState().NegotiatedProtocol == "h2" {
+				conn.Close()
+				return nil, errHTTP2Negotiated
+			}
+			return conn, nil
+		},
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	t.http2 = &http2.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			conn, err := ordered.dialTLS(ctx, network, addr, nil, httpProtocols)
+			if err != nil {
+				return nil, err
+			}
+			// Servers behind a load balancer may differ
+			if conn.ConnectionState().NegotiatedProtocol != "h2" {
+				conn.Close()
+				return nil, errHTTP2NotNegotiated
+			}
+			return conn, nil
+		},
+		IdleConnTimeout: 90 * time.Second,
+	}
+	return &tlsProfile{transport: t, ordered: ordered}
+}
+
+// errHTTP2Negotiated is returned when a server selects HTTP/2 for a
+// connection made for HTTP/1.1.
+var errHTTP2Negotiated = errors.New("server selected http/2")
+
+// errHTTP2NotNegotiated is returned when a server doesn't select HTTP/2 for
+// a connection made for HTTP/2.
+var errHTTP2NotNegotiated = errors.New("server did not select http/2")
+
+// maxHTTP2Hosts limits the hosts remembered by a profileTransport
+const maxHTTP2Hosts = 1000
+
+// profileTransport sends requests over connections made with a TLSDialer,
+// using HTTP/2 with servers that select it and HTTP/1.1 otherwise. Servers
+// are assumed to use HTTP/1.1 until they select HTTP/2, so the first
+// request to an HTTP/2 server makes an extra connection.
+type profileTransport struct {
+	http1      *http.Transport
+	http2      *http2.Transport
+	mutex      sync.Mutex
+	http2Hosts map[string]bool
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *profileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return t.http1.RoundTrip(req)
+	}
+	addr := canonicalAddr(req)
+	// Keep the body, which the transports close on errors
+	retry := *req
+	if t.usesHTTP2(addr) {
+		resp, err := t.http2.RoundTrip(req)
+		if !errors.Is(err, errHTTP2NotNegotiated) {
+			return resp, err
+		}
+		t.setHTTP2(addr, false)
+		if err := rewindBody(&retry, err); err != nil {
+			return nil, err
+		}
+		return t.http1.RoundTrip(&retry)
+	}
+	resp, err := t.http1.RoundTrip(req)
+	if !errors.Is(err, errHTTP2Negotiated) {
+		return resp, err
+	}
+	t.setHTTP2(addr, true)
+	if err := rewindBody(&retry, err); err != nil {
+		return nil, err
+	}
+	return t.http2.RoundTrip(&retry)
+}
+
+// rewindBody replaces the body of a request that failed with err so that it
+// can be sent again. It returns err if the body can't be replaced.
+func rewindBody(req *http.Request, err error) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if req.GetBody == nil {
+		return err
+	}
+	body, getErr := req.GetBody()
+	if getErr != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+func (t *profileTransport) usesHTTP2(addr string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.http2Hosts[addr]
+}
+
+func (t *profileTransport) setHTTP2(addr string, http2 bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !http2 {
+		delete(t.http2Hosts, addr)
+		return
+	}
+	if len(t.http2Hosts) >= maxHTTP2Hosts {
+		t.http2Hosts = map[string]bool{}
+	}
+	t.http2Hosts[addr] = true
+}
+
+var (
+	tlsProfilesMutex sync.RWMutex
+	tlsProfiles      = map[string]*tlsProfile{}
+)
+
+// RegisterTLSProfile makes a TLS profile available by name to handlers
+// created by GetHandler, replacing any profile with the same name. The Go
+// profile can't be replaced.
+func RegisterTLSProfile(name string, dialer TLSDialer) error {
+	if name == "" || name == TLSProfileGo {
+		return fmt.Errorf("tls profile %q can't be registered", name)
+	}
+	if dialer == nil {
+		return fmt.Errorf("tls profile %q requires a dialer", name)
+	}
+	tlsProfilesMutex.Lock()
+	defer tlsProfilesMutex.Unlock()
+	tlsProfiles[name] = newTLSProfile(dialer)
+	return nil
+}
+
+// TLSProfiles returns the names of the available TLS profiles.
+func TLSProfiles() []string {
+	tlsProfilesMutex.RLock()
+	defer tlsProfilesMutex.RUnlock()
+	names := []string{TLSProfileGo}
+	for name := range tlsProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+func lookupTLSProfile(name string) (*tlsProfile, bool) {
+	tlsProfilesMutex.RLock()
+	defer tlsProfilesMutex.RUnlock()
+	profile, ok := tlsProfiles[name]
+	return profile, ok
+}
@@ -0,0 +1,146 @@
+package burrow
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHelloServer returns a TLS server that supports HTTP/2 and records the
+// ClientHello of each connection, and a config that trusts its certificate.
+// It replies with the protocol and body of each request.
+func newHelloServer(t *testing.T) (*httptest.Server, chan *tls.ClientHelloInfo, *tls.Config) {
+	hellos := make(chan *tls.ClientHelloInfo, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Proto + " " + string(body)))
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			hellos <- hello
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	config := &tls.Config{RootCAs: server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	return server, hellos, config
+}
+
+// receivedHellos returns the ClientHellos received since the last call.
+func receivedHellos(hellos chan *tls.ClientHelloInfo) []*tls.ClientHelloInfo {
+	var received []*tls.ClientHelloInfo
+	for len(hellos) > 0 {
+		received = append(received, <-hellos)
+	}
+	return received
+}
+
+func TestTLSProfiles(t *testing.T) {
+	server, hellos, config := newHelloServer(t)
+	defer server.Close()
+
+	assert.EqualError(t, RegisterTLSProfile(TLSProfileGo, NewGoTLSDialer(config)), `tls profile "go" can't be registered`)
+	assert.EqualError(t, RegisterTLSProfile("", NewGoTLSDialer(config)), `tls profile "" can't be registered`)
+	assert.EqualError(t, RegisterTLSProfile("test-nil", nil), `tls profile "test-nil" requires a dialer`)
+	require.NoError(t, RegisterTLSProfile("test-go", NewGoTLSDialer(config)))
+	profiles := TLSProfiles()
+	assert.Equal(t, TLSProfileGo, profiles[0])
+	assert.NotContains(t, profiles[1:], TLSProfileGo)
+	assert.Contains(t, profiles, "test-go")
+
+	handler := GetHandler()
+	fetch := func(req *Request) string {
+		resp, err := handler(context.Background(), req)
+		require.NoError(t, err)
+		body, err := base64.StdEncoding.DecodeString(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	// The first request finds that the server selects HTTP/2, and is sent
+	// again over HTTP/2 with its body
+	body := base64.StdEncoding.EncodeToString([]byte("hello"))
+	assert.Equal(t, "HTTP/2.0 hello", fetch(&Request{URL: server.URL, Method: "POST", Body: body, TLSProfile: "test-go"}))
+	received := receivedHellos(hellos)
+	require.Len(t, received, 2)
+	for _, hello := range received {
+		assert.Equal(t, []string{"h2", "http/1.1"}, hello.SupportedProtos)
+	}
+
+	// Later requests reuse the HTTP/2 connection
+	assert.Equal(t, "HTTP/2.0 ", fetch(&Request{URL: server.URL, TLSProfile: "test-go"}))
+	assert.Empty(t, receivedHellos(hellos))
+
+	// Ordered headers are written over HTTP/1.1
+	resp, err := handler(context.Background(), &Request{
+		URL:            server.URL,
+		TLSProfile:     "test-go",
+		OrderedHeaders: []HeaderField{{"accept", "*/*"}},
+		Diagnostics:    true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("HTTP/1.1 ")), resp.Body)
+	require.NotNil(t, resp.Diagnostics.TLS)
+	assert.Equal(t, "http/1.1", resp.Diagnostics.TLS.NegotiatedProtocol)
+	received = receivedHellos(hellos)
+	require.Len(t, received, 1)
+	assert.Equal(t, []string{"http/1.1"}, received[0].SupportedProtos)
+
+	_, err = handler(context.Background(), &Request{URL: server.URL, TLSProfile: "netscape"})
+	var proxyErr *ProxyError
+	require.ErrorAs(t, err, &proxyErr)
+	assert.Equal(t, ProxyErrBadRequest, proxyErr.Type)
+	assert.Equal(t, "unknown tls profile: netscape", proxyErr.Message)
+}
+
+func TestClient_WithTLSProfile(t *testing.T) {
+	server, hellos, config := newHelloServer(t)
+	defer server.Close()
+	require.NoError(t, RegisterTLSProfile("test-trusted", NewGoTLSDialer(config)))
+
+	function := httptest.NewServer(NewHTTPHandler(GetHandler()))
+	defer function.Close()
+
+	// Only the profile trusts the server's certificate
+	client := NewClient(WithProxyURL(function.URL), WithTLSProfile("test-trusted"))
+	client.Timeout = 10 * time.Second
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2.0 ", string(body))
+	assert.NotEmpty(t, receivedHellos(hellos))
+}
+
+func TestProfileTransport_HTTP2Fallback(t *testing.T) {
+	server, _, config := newHelloServer(t)
+	defer server.Close()
+	server.TLS.NextProtos = []string{"http/1.1"}
+
+	// The server was seen selecting HTTP/2, but no longer does
+	profile := newTLSProfile(NewGoTLSDialer(config))
+	req, err := http.NewRequest("POST", server.URL, strings.NewReader("hello"))
+	require.NoError(t, err)
+	addr := canonicalAddr(req)
+	profile.transport.setHTTP2(addr, true)
+
+	resp, err := profile.transport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 hello", string(body))
+	assert.False(t, profile.transport.usesHTTP2(addr))
+}
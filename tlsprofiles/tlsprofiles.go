@@ -0,0 +1,108 @@
+// Package tlsprofiles registers the browser TLS profiles, TLSProfileChrome,
+// TLSProfileFirefox and TLSProfileSafari, with burrow. Import it in the
+// proxy to make them available to handlers created by burrow.GetHandler:
+//
+//	import _ "github.com/myzie/burrow/tlsprofiles"
+//
+// The ClientHellos are built with uTLS, which requires Go 1.24, so they are
+// kept out of the burrow module.
+package tlsprofiles
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/myzie/burrow"
+	utls "github.com/refraction-networking/utls"
+)
+
+func init() {
+	for name, dialer := range map[string]burrow.TLSDialer{
+		burrow.TLSProfileChrome:  NewChromeTLSDialer(nil),
+		burrow.TLSProfileFirefox: NewFirefoxTLSDialer(nil),
+		burrow.TLSProfileSafari:  NewSafariTLSDialer(nil),
+	} {
+		if err := burrow.RegisterTLSProfile(name, dialer); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// NewChromeTLSDialer creates a TLSDialer that sends the ClientHello of a
+// recent Chrome version. Only RootCAs and InsecureSkipVerify are used from
+// the config, which may be nil.
+func NewChromeTLSDialer(config *tls.Config) burrow.TLSDialer {
+	return &utlsDialer{hello: utls.HelloChrome_Auto, config: config}
+}
+
+// NewFirefoxTLSDialer creates a TLSDialer that sends the ClientHello of a
+// recent Firefox version. Only RootCAs and InsecureSkipVerify are used from
+// the config, which may be nil.
+func NewFirefoxTLSDialer(config *tls.Config) burrow.TLSDialer {
+	return &utlsDialer{hello: utls.HelloFirefox_Auto, config: config}
+}
+
+// NewSafariTLSDialer creates a TLSDialer that sends the ClientHello of
+// Safari on macOS. Only RootCAs and InsecureSkipVerify are used from the
+// config, which may be nil.
+func NewSafariTLSDialer(config *tls.Config) burrow.TLSDialer {
+	return &utlsDialer{hello: utls.HelloSafari_Auto, config: config}
+}
+
+// utlsDialer sends the ClientHello of a browser using utls.
+type utlsDialer struct {
+	hello  utls.ClientHelloID
+	config *tls.Config
+}
+
+func (d *utlsDialer) DialTLS(ctx context.Context, conn net.Conn, serverName string, protocols []string) (burrow.TLSConn, error) {
+	spec, err := utls.UTLSIdToSpec(d.hello)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build client hello: %w", err)
+	}
+	// Offer the browser's protocols, leaving out those the caller can't
+	// speak, e.g. h2 for ordered headers
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = slices.DeleteFunc(alpn.AlpnProtocols, func(protocol string) bool {
+				return !slices.Contains(protocols, protocol)
+			})
+		}
+	}
+	config := &utls.Config{ServerName: serverName}
+	if d.config != nil {
+		config.RootCAs = d.config.RootCAs
+		config.InsecureSkipVerify = d.config.InsecureSkipVerify
+	}
+	uconn := utls.UClient(conn, config, utls.HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		return nil, fmt.Errorf("failed to build client hello: %w", err)
+	}
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return &utlsConn{uconn}, nil
+}
+
+// utlsConn reports the state of a utls connection as a tls.ConnectionState.
+type utlsConn struct {
+	*utls.UConn
+}
+
+func (c *utlsConn) ConnectionState() tls.ConnectionState {
+	state := c.UConn.ConnectionState()
+	return tls.ConnectionState{
+		Version:                    state.Version,
+		HandshakeComplete:          state.HandshakeComplete,
+		DidResume:                  state.DidResume,
+		CipherSuite:                state.CipherSuite,
+		NegotiatedProtocol:         state.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual: state.NegotiatedProtocolIsMutual,
+		ServerName:                 state.ServerName,
+		PeerCertificates:           state.PeerCertificates,
+		VerifiedChains:             state.VerifiedChains,
+	}
+}
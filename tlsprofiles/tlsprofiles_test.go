@@ -0,0 +1,146 @@
+package tlsprofiles
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/myzie/burrow"
+	utls "github.com/refraction-networking/utls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHelloServer returns a TLS server that supports HTTP/2 and records the
+// ClientHello of each connection, and a config that trusts its certificate.
+func newHelloServer(t *testing.T) (*httptest.Server, chan *tls.ClientHelloInfo, *tls.Config) {
+	hellos := make(chan *tls.ClientHelloInfo, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			hellos <- hello
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	config := &tls.Config{RootCAs: server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	return server, hellos, config
+}
+
+// lastHello returns the last ClientHello received by a hello server.
+func lastHello(t *testing.T, hellos chan *tls.ClientHelloInfo) *tls.ClientHelloInfo {
+	require.NotEmpty(t, hellos)
+	var hello *tls.ClientHelloInfo
+	for len(hellos) > 0 {
+		hello = <-hellos
+	}
+	return hello
+}
+
+// presetHello returns the ClientHello that utls sends for the browser.
+func presetHello(t *testing.T, server *httptest.Server, hellos chan *tls.ClientHelloInfo, id utls.ClientHelloID) *tls.ClientHelloInfo {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	host, _, _ := net.SplitHostPort(server.Listener.Addr().String())
+	uconn := utls.UClient(conn, &utls.Config{ServerName: host, InsecureSkipVerify: true}, id)
+	require.NoError(t, uconn.Handshake())
+	return lastHello(t, hellos)
+}
+
+// withoutGREASE replaces GREASE values (RFC 8701), which are random, so
+// that ClientHellos can be compared.
+func withoutGREASE(values []uint16) []uint16 {
+	normalized := make([]uint16, len(values))
+	for i, v := range values {
+		if v&0x0f0f == 0x0a0a && v>>8 == v&0xff {
+			v = utls.GREASE_PLACEHOLDER
+		}
+		normalized[i] = v
+	}
+	return normalized
+}
+
+func curveIDs[T ~uint16](curves []T) []uint16 {
+	ids := make([]uint16, len(curves))
+	for i, curve := range curves {
+		ids[i] = uint16(curve)
+	}
+	return ids
+}
+
+func TestTLSProfiles(t *testing.T) {
+	assert.Subset(t, burrow.TLSProfiles(), []string{burrow.TLSProfileChrome, burrow.TLSProfileFirefox, burrow.TLSProfileSafari})
+
+	server, hellos, config := newHelloServer(t)
+	defer server.Close()
+	handler := burrow.GetHandler()
+
+	for _, test := range []struct {
+		name   string
+		id     utls.ClientHelloID
+		dialer burrow.TLSDialer
+	}{
+		{"test-chrome", utls.HelloChrome_Auto, NewChromeTLSDialer(config)},
+		{"test-firefox", utls.HelloFirefox_Auto, NewFirefoxTLSDialer(config)},
+		{"test-safari", utls.HelloSafari_Auto, NewSafariTLSDialer(config)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, burrow.RegisterTLSProfile(test.name, test.dialer))
+			spec, err := utls.UTLSIdToSpec(test.id)
+			require.NoError(t, err)
+			var alpn []string
+			var curves []uint16
+			for _, ext := range spec.Extensions {
+				switch ext := ext.(type) {
+				case *utls.ALPNExtension:
+					alpn = ext.AlpnProtocols
+				case *utls.SupportedCurvesExtension:
+					curves = curveIDs(ext.Curves)
+				}
+			}
+			preset := presetHello(t, server, hellos, test.id)
+
+			resp, err := handler(context.Background(), &burrow.Request{URL: server.URL, TLSProfile: test.name})
+			require.NoError(t, err)
+			assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("HTTP/2.0")), resp.Body)
+			hello := lastHello(t, hellos)
+			assert.Equal(t, withoutGREASE(spec.CipherSuites), withoutGREASE(hello.CipherSuites))
+			assert.Equal(t, curves, withoutGREASE(curveIDs(hello.SupportedCurves)))
+			assert.Equal(t, alpn, hello.SupportedProtos)
+			assert.Contains(t, alpn, "h2")
+			// Chrome shuffles its extensions
+			assert.ElementsMatch(t, withoutGREASE(preset.Extensions), withoutGREASE(hello.Extensions))
+
+			// Ordered headers are written over HTTP/1.1, so only it is offered
+			resp, err = handler(context.Background(), &burrow.Request{
+				URL:            server.URL,
+				TLSProfile:     test.name,
+				OrderedHeaders: []burrow.HeaderField{{Name: "accept", Value: "*/*"}},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("HTTP/1.1")), resp.Body)
+			hello = lastHello(t, hellos)
+			assert.Equal(t, withoutGREASE(spec.CipherSuites), withoutGREASE(hello.CipherSuites))
+			assert.Equal(t, []string{"http/1.1"}, hello.SupportedProtos)
+			assert.ElementsMatch(t, withoutGREASE(preset.Extensions), withoutGREASE(hello.Extensions))
+		})
+	}
+
+	// Each profile has a distinct fingerprint
+	var ciphers [][]uint16
+	for _, id := range []utls.ClientHelloID{utls.HelloChrome_Auto, utls.HelloFirefox_Auto, utls.HelloSafari_Auto} {
+		ciphers = append(ciphers, withoutGREASE(presetHello(t, server, hellos, id).CipherSuites))
+	}
+	assert.NotEqual(t, ciphers[0], ciphers[1])
+	assert.NotEqual(t, ciphers[1], ciphers[2])
+	assert.NotEqual(t, ciphers[0], ciphers[2])
+}
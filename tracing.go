@@ -0,0 +1,117 @@
+package burrow
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/myzie/burrow"
+
+// tracePropagator carries W3C trace context in the Burrow request envelope.
+var tracePropagator = propagation.TraceContext{}
+
+// SpanTiming describes a span recorded by the proxy while handling a request.
+// Start is the offset from the start of the handler, in seconds.
+type SpanTiming struct {
+	Name     string  `json:"name"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+}
+
+// InjectTraceContext stores the trace context from ctx in the request
+// envelope. The upstream request headers are not modified.
+func InjectTraceContext(ctx context.Context, req *Request) {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		req.TraceContext = carrier
+	}
+}
+
+// ExtractTraceContext returns a context containing the trace context carried
+// in the request envelope, if any. Used by proxy handlers to continue a trace
+// started by the client.
+func ExtractTraceContext(ctx context.Context, req *Request) context.Context {
+	if len(req.TraceContext) == 0 {
+		return ctx
+	}
+	return tracePropagator.Extract(ctx, propagation.MapCarrier(req.TraceContext))
+}
+
+// startClientSpan starts the client span for a proxied request.
+func (t *Transport) startClientSpan(ctx context.Context, method, host string, attempt int) (context.Context, trace.Span) {
+	return t.tracerProvider.Tracer(tracerName).Start(ctx, "burrow.proxy",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("server.address", host),
+			attribute.String("burrow.proxy.url", t.ProxyURL()),
+			attribute.Int("burrow.attempt", attempt),
+		))
+}
+
+// endClientSpan records the outcome of a proxied request on the client span.
+func endClientSpan(span trace.Span, serResp *Response, err error) {
+	defer span.End()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.String("burrow.error.class", string(ClassifyError(err))))
+		return
+	}
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", serResp.StatusCode),
+		attribute.String("burrow.proxy.name", serResp.ProxyName),
+		attribute.Float64("burrow.upstream.duration", serResp.Duration),
+	)
+	for _, s := range serResp.Spans {
+		span.AddEvent("burrow.server.span", trace.WithAttributes(
+			attribute.String("name", s.Name),
+			attribute.Float64("start", s.Start),
+			attribute.Float64("duration", s.Duration),
+		))
+	}
+}
+
+// upstreamTracer records spans for the phases of an upstream request made by
+// the handler. Spans are only exported if the handler context carries a
+// recording span, but timings are always collected.
+type upstreamTracer struct {
+	ctx   context.Context
+	start time.Time
+	trace *diagnosticsTrace
+	spans []SpanTiming
+}
+
+func newUpstreamTracer(ctx context.Context, start time.Time, trace *diagnosticsTrace) *upstreamTracer {
+	return &upstreamTracer{ctx: ctx, start: start, trace: trace}
+}
+
+// record adds a completed span with the given name and time range.
+func (u *upstreamTracer) record(name string, start, end time.Time) {
+	u.spans = append(u.spans, SpanTiming{
+		Name:     name,
+		Start:    start.Sub(u.start).Seconds(),
+		Duration: end.Sub(start).Seconds(),
+	})
+	parent := trace.SpanFromContext(u.ctx)
+	if !parent.IsRecording() {
+		return
+	}
+	_, span := parent.TracerProvider().Tracer(tracerName).Start(u.ctx, "burrow.upstream."+name,
+		trace.WithTimestamp(start))
+	span.End(trace.WithTimestamp(end))
+}
+
+// finish records the connection phases and returns all span timings.
+func (u *upstreamTracer) finish() []SpanTiming {
+	for _, p := range u.trace.phases() {
+		u.record(p.name, p.phase.start, p.phase.end)
+	}
+	return u.spans
+}
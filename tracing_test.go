@@ -0,0 +1,85 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Trace context must travel in the envelope, not the upstream headers
+		assert.Empty(t, r.Header.Get("Traceparent"))
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	handler := GetHandler()
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var serReq Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&serReq))
+		assert.Contains(t, serReq.TraceContext, "traceparent")
+		ctx := ExtractTraceContext(r.Context(), &serReq)
+		ctx, span := tp.Tracer("test").Start(ctx, "handle", trace.WithSpanKind(trace.SpanKindServer))
+		resp, err := handler(ctx, &serReq)
+		span.End()
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer mockProxy.Close()
+
+	client := NewClient(WithProxyURL(mockProxy.URL), WithTracerProvider(tp))
+	resp, err := client.Get(upstream.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	spans := exporter.GetSpans()
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	clientSpan, ok := byName["burrow.proxy"]
+	require.True(t, ok, "client span not exported")
+	assert.Equal(t, trace.SpanKindClient, clientSpan.SpanKind)
+
+	server, ok := byName["handle"]
+	require.True(t, ok, "server span not exported")
+	assert.Equal(t, clientSpan.SpanContext.TraceID(), server.SpanContext.TraceID())
+	assert.Equal(t, clientSpan.SpanContext.SpanID(), server.Parent.SpanID())
+
+	for _, name := range []string{"burrow.upstream.connect", "burrow.upstream.read_body"} {
+		span, ok := byName[name]
+		require.True(t, ok, "%s span not exported", name)
+		assert.Equal(t, server.SpanContext.SpanID(), span.Parent.SpanID())
+	}
+
+	var serverSpanEvents int
+	for _, event := range clientSpan.Events {
+		if event.Name == "burrow.server.span" {
+			serverSpanEvents++
+		}
+	}
+	assert.Greater(t, serverSpanEvents, 0)
+}
+
+func TestTracing_Disabled(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	resp, err := GetHandler()(context.Background(), &Request{URL: upstream.URL})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Spans)
+}
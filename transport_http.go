@@ -1,13 +1,15 @@
 package burrow
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var _ http.RoundTripper = &Transport{}
@@ -20,6 +22,11 @@ const (
 	ProxyErrExceededMaxBodySize   ErrorCode = 2
 	ProxyErrDisallowedContentType ErrorCode = 3
 	ProxyErrTimeout               ErrorCode = 4
+	// ProxyErrThrottled means the function was not invoked because of
+	// throttling, e.g. the Invoke API or a Function URL returned status 429
+	ProxyErrThrottled ErrorCode = 5
+	// ProxyErrUnavailable means the Invoke API failed with a 5xx status
+	ProxyErrUnavailable ErrorCode = 6
 )
 
 type ProxyError struct {
@@ -43,80 +50,230 @@ func ProxyErrorf(code ErrorCode, format string, args ...any) *ProxyError {
 type ProxyCallback func(ctx context.Context, proxyResponse *Response)
 
 // Transport implements the http.RoundTripper interface. Used to proxy HTTP
-// requests via a Burrow HTTP endpoint.
+// requests via a Burrow function, which is invoked through a Backend.
 type Transport struct {
-	proxyURL            string
-	method              string
-	client              *http.Client
+	backend             Backend
 	callback            ProxyCallback
 	timeout             time.Duration
 	maxResponseBytes    int64
 	allowedContentTypes []string
+	stripHeaders        []string
+	profiles            *profileSelector
+	orderedHeaders      bool
+	tlsProfile          string
+	diagnostics         bool
+	metadataHeaders     bool
+	hooks               *Hooks
+	tracerProvider      trace.TracerProvider
+	metrics             Metrics
+	accountant          *UsageAccountant
+	egressTracker       *EgressTracker
+	retireEvery         int64
+	requests            atomic.Int64
 }
 
 // RoundTrip implements the http.RoundTripper interface
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	serReq, err := SerializeRequest(req)
+	if t.accountant != nil {
+		reserved, err := t.accountant.reserve()
+		if err != nil {
+			return nil, err
+		}
+		defer t.accountant.release(reserved)
+	}
+	attempt := t.beginAttempt(req)
+	var stats roundTripStats
+	resp, serResp, err := t.roundTrip(attempt.ctx, req, &stats)
+	attempt.end(serResp, err, &stats, true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to serialize request: %w", err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// observedAttempt reports one attempt to proxy a request to the hooks,
+// tracer, metrics and usage accountant of a transport.
+type observedAttempt struct {
+	t       *Transport
+	req     *http.Request
+	ctx     context.Context // carries the client span
+	span    trace.Span
+	attempt int
+	start   time.Time
+}
+
+// beginAttempt invokes the OnRequest hook and starts the client span.
+func (t *Transport) beginAttempt(req *http.Request) *observedAttempt {
+	ctx := req.Context()
+	a := &observedAttempt{t: t, req: req, attempt: attemptFromContext(ctx), start: time.Now()}
+	t.hooks.onRequest(ctx, &HookEvent{
+		Request:  req,
+		Attempt:  a.attempt,
+		ProxyURL: t.ProxyURL(),
+	})
+	a.ctx, a.span = t.startClientSpan(ctx, req.Method, req.URL.Host, a.attempt)
+	return a
+}
+
+// end records the outcome of the attempt. The usage accountant is skipped
+// unless account is set, since a batch is accounted as one invocation.
+func (a *observedAttempt) end(serResp *Response, err error, stats *roundTripStats, account bool) {
+	t, ctx := a.t, a.req.Context()
+	endClientSpan(a.span, serResp, err)
+	if t.metrics != nil || (account && t.accountant != nil) {
+		obs := newRequestObservation(t.ProxyURL(), serResp, err, time.Since(a.start), stats)
+		if t.metrics != nil {
+			t.metrics.ObserveRequest(obs)
+		}
+		if account && t.accountant != nil {
+			t.accountant.ObserveRequest(obs)
+		}
 	}
-	serReq.Timeout = t.timeout.Seconds()
-	serReq.MaxResponseBytes = t.maxResponseBytes
-	serReq.AllowedContentTypes = t.allowedContentTypes
-	payload, err := json.Marshal(serReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		t.hooks.onProxyError(ctx, &HookEvent{
+			Request:  a.req,
+			Attempt:  a.attempt,
+			ProxyURL: t.ProxyURL(),
+			Err:      err,
+			Duration: time.Since(a.start),
+		})
+		return
 	}
-	proxyReq, err := http.NewRequestWithContext(req.Context(), t.method, t.proxyURL, bytes.NewReader(payload))
+	t.hooks.onResponse(ctx, &HookEvent{
+		Request:    a.req,
+		Attempt:    a.attempt,
+		ProxyURL:   t.ProxyURL(),
+		ProxyName:  serResp.ProxyName,
+		StatusCode: serResp.StatusCode,
+		Duration:   time.Since(a.start),
+		Response:   serResp,
+	})
+}
+
+// roundTrip proxies the request. The context carries the client span and is
+// used for the proxy request rather than the context of req. The number of
+// bytes transferred is recorded in stats.
+func (t *Transport) roundTrip(ctx context.Context, req *http.Request, stats *roundTripStats) (*http.Response, *Response, error) {
+	serReq, err := t.serializeRequest(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create proxy request: %w", err)
+		return nil, nil, err
 	}
-	proxyReq.Header.Set("Content-Type", "application/json")
-	proxyResp, err := t.client.Do(proxyReq)
+	payload, err := json.Marshal(serReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request to proxy: %w", err)
+		return nil, nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	defer proxyResp.Body.Close()
-	body, err := io.ReadAll(proxyResp.Body)
+	stats.sent = int64(len(payload))
+	start := time.Now()
+	body, err := t.backend.Invoke(ctx, payload)
+	stats.received = int64(len(body))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read proxy response body: %w", err)
-	}
-	if proxyResp.StatusCode != http.StatusOK {
-		var errResp ProxyError
-		if err := json.Unmarshal(body, &errResp); err != nil {
-			return nil, &ProxyError{
-				Message: fmt.Sprintf("proxy returned non-200 status code: %d", proxyResp.StatusCode),
-				Type:    ProxyErrUnknown,
-			}
-		}
-		return nil, &errResp
+		return nil, nil, err
 	}
 	var serResp Response
 	if err := json.Unmarshal(body, &serResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, nil, &protocolError{fmt.Errorf("failed to unmarshal response: %w", err)}
 	}
+	resp, err := t.deserializeResponse(req, &serResp, time.Since(start))
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, &serResp, nil
+}
+
+// serializeRequest converts the request into a Request configured with the
+// transport's settings.
+func (t *Transport) serializeRequest(ctx context.Context, req *http.Request) (*Request, error) {
+	serReq, err := SerializeRequest(req, t.stripHeaders...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize request: %w", err)
+	}
+	profile := t.profiles.profile(ctx)
+	if profile != nil {
+		profile.apply(serReq)
+	}
+	if t.orderedHeaders {
+		serReq.OrderedHeaders = orderHeaders(serReq.Headers, profile)
+	}
+	serReq.TLSProfile = t.tlsProfile
+	serReq.Timeout = t.timeout.Seconds()
+	serReq.MaxResponseBytes = t.maxResponseBytes
+	serReq.AllowedContentTypes = t.allowedContentTypes
+	if limits := requestLimitsFromContext(ctx); limits != nil {
+		if limits.Timeout > 0 {
+			serReq.Timeout = limits.Timeout.Seconds()
+		}
+		if limits.MaxResponseBytes > 0 {
+			serReq.MaxResponseBytes = limits.MaxResponseBytes
+		}
+		if len(limits.AllowedContentTypes) > 0 {
+			serReq.AllowedContentTypes = limits.AllowedContentTypes
+		}
+	}
+	serReq.Diagnostics = t.diagnostics
+	serReq.Retire = retireFromContext(ctx) || t.shouldRetire(ctx)
+	InjectTraceContext(ctx, serReq)
+	return serReq, nil
+}
+
+// RequestLimits overrides the upstream timeout and response limits of a
+// Transport for one request. Zero values keep the transport's settings.
+type RequestLimits struct {
+	Timeout             time.Duration
+	MaxResponseBytes    int64
+	AllowedContentTypes []string
+}
+
+type requestLimitsKey struct{}
+
+// UseRequestLimits returns a context whose requests are proxied with the
+// given limits rather than those of the transport.
+func UseRequestLimits(ctx context.Context, limits *RequestLimits) context.Context {
+	return context.WithValue(ctx, requestLimitsKey{}, limits)
+}
+
+func requestLimitsFromContext(ctx context.Context) *RequestLimits {
+	limits, _ := ctx.Value(requestLimitsKey{}).(*RequestLimits)
+	return limits
+}
+
+// deserializeResponse converts a proxy response into an http.Response with
+// proxy metadata attached.
+func (t *Transport) deserializeResponse(req *http.Request, serResp *Response, roundTrip time.Duration) (*http.Response, error) {
 	if t.callback != nil {
-		t.callback(req.Context(), &serResp)
+		t.callback(req.Context(), serResp)
 	}
-	return DeserializeResponse(&serResp)
+	resp, err := DeserializeResponse(serResp)
+	if err != nil {
+		return nil, &protocolError{err}
+	}
+	md := newMetadata(t.ProxyURL(), serResp, roundTrip)
+	if t.egressTracker != nil {
+		t.egressTracker.Observe(md)
+	}
+	if t.metadataHeaders {
+		setMetadataHeaders(resp.Header, md)
+	}
+	attachMetadata(req, resp, md)
+	return resp, nil
 }
 
 // NewTransport creates a new Transport
 func NewTransport(proxyURL string, method string, c ...*http.Client) *Transport {
-	return &Transport{
-		proxyURL: proxyURL,
-		method:   "POST",
-		client:   &http.Client{},
-	}
+	return NewTransportWithBackend(NewHTTPBackend(proxyURL))
 }
 
 // NewTransportWithClient creates a new Transport that uses the provided
 // HTTP client internally. If you're not sure, use NewTransport instead.
 func NewTransportWithClient(proxyURL string, method string, c *http.Client) *Transport {
+	return NewTransportWithBackend(NewHTTPBackend(proxyURL).WithMethod(method).WithClient(c))
+}
+
+// NewTransportWithBackend creates a new Transport that invokes the Burrow
+// function through the provided backend.
+func NewTransportWithBackend(backend Backend) *Transport {
 	return &Transport{
-		proxyURL: proxyURL,
-		method:   method,
-		client:   c,
+		backend:        backend,
+		tracerProvider: otel.GetTracerProvider(),
 	}
 }
 
@@ -144,3 +301,136 @@ func (t *Transport) WithAllowedContentTypes(allowedContentTypes []string) *Trans
 	t.allowedContentTypes = allowedContentTypes
 	return t
 }
+
+// WithStripHeaders sets request headers that are never sent to the proxy,
+// e.g. X-Forwarded-For or tracing headers added by other middleware
+func (t *Transport) WithStripHeaders(headers ...string) *Transport {
+	t.stripHeaders = headers
+	return t
+}
+
+// WithHeaderProfiles sends requests with the headers of a browser profile
+// from the catalog, chosen per request or once for this transport's proxy.
+// Headers set on a request take precedence over the profile.
+func (t *Transport) WithHeaderProfiles(catalog *ProfileCatalog, rotation ProfileRotation) *Transport {
+	t.profiles = &profileSelector{catalog: catalog, rotation: rotation}
+	return t
+}
+
+// WithOrderedHeaders asks the proxy to send headers in order with their
+// casing intact: those of the header profile first, in the browser's order,
+// then the rest sorted by name. The proxy then uses HTTP/1.1. Set headers
+// directly in the http.Header map, e.g. req.Header["x-api-key"], to keep
+// their casing.
+func (t *Transport) WithOrderedHeaders(enabled bool) *Transport {
+	t.orderedHeaders = enabled
+	return t
+}
+
+// WithTLSProfile asks the proxy to connect upstream with the named TLS
+// profile, e.g. TLSProfileChrome, so that the TLS fingerprint matches a
+// browser. Connections made with a profile offer HTTP/2 and HTTP/1.1, and
+// use HTTP/2 when the server selects it. With ordered headers only HTTP/1.1
+// is offered.
+func (t *Transport) WithTLSProfile(name string) *Transport {
+	t.tlsProfile = name
+	return t
+}
+
+// WithDiagnostics enables collection of upstream connection diagnostics
+// (remote address, protocol, timings and TLS details) by the proxy
+func (t *Transport) WithDiagnostics(enabled bool) *Transport {
+	t.diagnostics = enabled
+	return t
+}
+
+// WithMetadataHeaders enables synthesized X-Burrow-* response headers that
+// describe the proxy that served the request and how long it took
+func (t *Transport) WithMetadataHeaders(enabled bool) *Transport {
+	t.metadataHeaders = enabled
+	return t
+}
+
+// WithHooks sets the lifecycle hooks invoked for each proxied request
+func (t *Transport) WithHooks(hooks *Hooks) *Transport {
+	t.hooks = hooks
+	return t
+}
+
+// ProxyURL returns the URL of the proxy used by the transport
+func (t *Transport) ProxyURL() string {
+	return t.backend.URL()
+}
+
+// Backend returns the backend used to invoke the proxy
+func (t *Transport) Backend() Backend {
+	return t.backend
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used to create
+// client spans. Defaults to the global tracer provider.
+func (t *Transport) WithTracerProvider(tp trace.TracerProvider) *Transport {
+	t.tracerProvider = tp
+	return t
+}
+
+// WithMetrics sets the collector that records measurements of each proxied
+// request
+func (t *Transport) WithMetrics(metrics Metrics) *Transport {
+	t.metrics = metrics
+	return t
+}
+
+// WithUsageAccountant sets the accountant that records the estimated cost of
+// each proxied request. If the accountant has a budget, requests are rejected
+// with ErrBudgetExceeded once it is reached.
+func (t *Transport) WithUsageAccountant(accountant *UsageAccountant) *Transport {
+	t.accountant = accountant
+	return t
+}
+
+// WithRetireEvery asks the proxy to retire its execution environment after
+// every n requests sent through this transport, so that later requests leave
+// from a new execution environment. Retries are not counted. Zero disables
+// retirement.
+func (t *Transport) WithRetireEvery(n int) *Transport {
+	t.retireEvery = int64(n)
+	return t
+}
+
+func (t *Transport) shouldRetire(ctx context.Context) bool {
+	if t.retireEvery <= 0 || attemptFromContext(ctx) > 1 {
+		return false
+	}
+	return t.requests.Add(1)%t.retireEvery == 0
+}
+
+// WithEgressTracker sets the tracker that records the egress IP reported by
+// the proxy for each response
+func (t *Transport) WithEgressTracker(tracker *EgressTracker) *Transport {
+	t.egressTracker = tracker
+	return t
+}
+
+// WithSigV4 signs proxy requests with SigV4 using the provided credentials,
+// or DefaultCredentials if nil. Use this with Function URLs that use the
+// AWS_IAM auth type. If region is empty it is taken from the Function URL.
+// It has no effect unless the transport uses an HTTPBackend, which may be
+// encrypted with WithEncryption before or after this is called.
+func (t *Transport) WithSigV4(region string, credentials CredentialsProvider) *Transport {
+	backend := t.backend
+	if encrypted, ok := backend.(*encryptedBackend); ok {
+		backend = encrypted.backend
+	}
+	if b, ok := backend.(*HTTPBackend); ok {
+		b.WithSigV4(region, credentials)
+	}
+	return t
+}
+
+// WithEncryption encrypts requests to the proxy and its replies with keys
+// from the keyring. The proxy must be configured with the same keys.
+func (t *Transport) WithEncryption(keyring *Keyring) *Transport {
+	t.backend = NewEncryptedBackend(t.backend, keyring)
+	return t
+}
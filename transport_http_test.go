@@ -117,7 +117,31 @@ func TestNewTransportWithClient(t *testing.T) {
 	customClient := &http.Client{Timeout: 5 * time.Second}
 	transport := NewTransportWithClient("http://proxy", "POST", customClient)
 
-	assert.Equal(t, customClient, transport.client)
-	assert.Equal(t, "http://proxy", transport.proxyURL)
-	assert.Equal(t, "POST", transport.method)
+	backend, ok := transport.Backend().(*HTTPBackend)
+	require.True(t, ok)
+	assert.Equal(t, customClient, backend.client)
+	assert.Equal(t, "http://proxy", transport.ProxyURL())
+	assert.Equal(t, "POST", backend.method)
+}
+
+func TestTransport_RequestLimits(t *testing.T) {
+	transport := NewTransport("http://proxy", "POST").
+		WithTimeout(5 * time.Second).
+		WithMaxResponseBytes(1000)
+
+	req := httptest.NewRequest("GET", "https://example.com", nil)
+	serReq, err := transport.serializeRequest(req.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, serReq.Timeout)
+	assert.Equal(t, int64(1000), serReq.MaxResponseBytes)
+
+	ctx := UseRequestLimits(req.Context(), &RequestLimits{
+		Timeout:             time.Second,
+		AllowedContentTypes: []string{"text/html"},
+	})
+	serReq, err = transport.serializeRequest(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, serReq.Timeout)
+	assert.Equal(t, int64(1000), serReq.MaxResponseBytes)
+	assert.Equal(t, []string{"text/html"}, serReq.AllowedContentTypes)
 }
@@ -2,6 +2,8 @@ package burrow
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"math"
 	"net/http"
@@ -14,11 +16,22 @@ var _ http.RoundTripper = &RoundRobinTransport{}
 // RoundRobinTransport is an http.RoundTripper that sends requests using a
 // rotating set of http.Transports.
 type RoundRobinTransport struct {
-	transports []http.RoundTripper
-	mutex      sync.Mutex
-	index      int
-	retries    int
-	retryable  map[int]bool
+	transports       []http.RoundTripper
+	mutex            sync.Mutex
+	index            int
+	retries          int
+	retryable        map[int]bool
+	hooks            *Hooks
+	circuitThreshold int
+	circuitCooldown  time.Duration
+	circuits         []circuit
+	metrics          Metrics
+}
+
+// circuit tracks consecutive proxy failures for one transport.
+type circuit struct {
+	failures  int
+	openUntil time.Time
 }
 
 // NewRoundRobinTransport creates a new RoundRobinTransport that rotates through
@@ -27,6 +40,7 @@ func NewRoundRobinTransport(transports []http.RoundTripper) *RoundRobinTransport
 	return &RoundRobinTransport{
 		transports: transports,
 		retryable:  defaultRetryableCodes,
+		circuits:   make([]circuit, len(transports)),
 	}
 }
 
@@ -40,6 +54,7 @@ func (r *RoundRobinTransport) WithRetries(retries int) *RoundRobinTransport {
 }
 
 // WithRetryableCodes sets the list of HTTP status codes that should be retried.
+// Including 429 also retries requests on which the proxy itself was throttled.
 func (r *RoundRobinTransport) WithRetryableCodes(codes []int) *RoundRobinTransport {
 	retryable := map[int]bool{}
 	for _, code := range codes {
@@ -49,6 +64,34 @@ func (r *RoundRobinTransport) WithRetryableCodes(codes []int) *RoundRobinTranspo
 	return r
 }
 
+// WithHooks sets the lifecycle hooks invoked on retries and when a proxy's
+// circuit opens.
+func (r *RoundRobinTransport) WithHooks(hooks *Hooks) *RoundRobinTransport {
+	r.hooks = hooks
+	return r
+}
+
+// WithCircuitBreaker takes a transport out of rotation for the cooldown period
+// after it fails to proxy threshold consecutive requests. A threshold of zero
+// disables the circuit breaker. After the cooldown the transport is tried
+// again, and a single failure takes it out of rotation for another cooldown.
+// If every transport is out of rotation, the transports are used in order
+// regardless.
+func (r *RoundRobinTransport) WithCircuitBreaker(threshold int, cooldown time.Duration) *RoundRobinTransport {
+	if threshold < 0 {
+		threshold = 0
+	}
+	r.circuitThreshold = threshold
+	r.circuitCooldown = cooldown
+	return r
+}
+
+// WithMetrics sets the collector that records retries.
+func (r *RoundRobinTransport) WithMetrics(metrics Metrics) *RoundRobinTransport {
+	r.metrics = metrics
+	return r
+}
+
 // RoundTrip implements the http.RoundTripper interface.
 func (r *RoundRobinTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// Clone the request body if it exists
@@ -61,25 +104,37 @@ func (r *RoundRobinTransport) RoundTrip(req *http.Request) (*http.Response, erro
 		}
 		req.Body.Close()
 	}
+	ctx := req.Context()
 	var lastResp *http.Response
 	for i := 0; i <= r.retries; i++ {
+		attemptReq := req.WithContext(withAttempt(ctx, i+1))
 		// Recreate the body for each attempt
 		if bodyBytes != nil {
-			req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+			attemptReq.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 		}
-		transport := r.nextTransport()
-		response, err := transport.RoundTrip(req)
+		index, transport := r.nextTransport()
+		response, err := transport.RoundTrip(attemptReq)
+		r.recordResult(ctx, attemptReq, index, err)
+		statusCode := http.StatusTooManyRequests
 		if err != nil {
 			// This means the proxying itself failed, which we will not retry
-			return nil, err
-		}
-		// Return immediately if the status code is in the 2xx range
-		if response.StatusCode >= 200 && response.StatusCode < 300 {
-			return response, nil
-		}
-		// Return immediately if the status code is not retryable
-		if !r.isRetryable(response.StatusCode) {
-			return response, nil
+			// unless the proxy was throttled and another attempt is allowed
+			if !r.shouldRetryError(err) || i == r.retries {
+				if lastResp != nil {
+					lastResp.Body.Close()
+				}
+				return nil, err
+			}
+		} else {
+			// Return immediately if the status code is in the 2xx range
+			if response.StatusCode >= 200 && response.StatusCode < 300 {
+				return response, nil
+			}
+			// Return immediately if the status code is not retryable
+			if !r.isRetryable(response.StatusCode) {
+				return response, nil
+			}
+			statusCode = response.StatusCode
 		}
 		// Close the response body if we're not returning it
 		if lastResp != nil {
@@ -91,12 +146,23 @@ func (r *RoundRobinTransport) RoundTrip(req *http.Request) (*http.Response, erro
 		if i < r.retries {
 			// Calculate backoff duration starting from 100ms
 			backoff := time.Duration(math.Pow(2, float64(i))*100) * time.Millisecond
+			if r.metrics != nil {
+				r.metrics.ObserveRetry(proxyURLOf(transport), statusCode)
+			}
+			r.hooks.onRetry(ctx, &HookEvent{
+				Request:    req,
+				Attempt:    i + 1,
+				ProxyURL:   proxyURLOf(transport),
+				StatusCode: statusCode,
+				Err:        err,
+				Backoff:    backoff,
+			})
 			// Use context-aware sleep
 			timer := time.NewTimer(backoff)
 			select {
-			case <-req.Context().Done():
+			case <-ctx.Done():
 				timer.Stop()
-				return lastResp, req.Context().Err()
+				return lastResp, ctx.Err()
 			case <-timer.C:
 				// Continue with next retry
 			}
@@ -105,18 +171,65 @@ func (r *RoundRobinTransport) RoundTrip(req *http.Request) (*http.Response, erro
 	return lastResp, nil
 }
 
-func (r *RoundRobinTransport) nextTransport() http.RoundTripper {
+// recordResult updates the circuit for the transport at the given index.
+func (r *RoundRobinTransport) recordResult(ctx context.Context, req *http.Request, index int, err error) {
+	if r.circuitThreshold == 0 {
+		return
+	}
+	r.mutex.Lock()
+	c := &r.circuits[index]
+	if !isProxyFailure(err) {
+		c.failures = 0
+		r.mutex.Unlock()
+		return
+	}
+	c.failures++
+	// Once the cooldown ends a single failure reopens the circuit, since
+	// failures are only reset by a success
+	now := time.Now()
+	opened := c.failures >= r.circuitThreshold && !now.Before(c.openUntil)
+	if opened {
+		c.openUntil = now.Add(r.circuitCooldown)
+	}
+	r.mutex.Unlock()
+	if opened {
+		r.hooks.onCircuitOpen(ctx, &HookEvent{
+			Request:    req,
+			Attempt:    attemptFromContext(req.Context()),
+			ProxyURL:   proxyURLOf(r.transports[index]),
+			Err:        err,
+			ErrorClass: ClassifyError(err),
+			Cooldown:   r.circuitCooldown,
+		})
+	}
+}
+
+func (r *RoundRobinTransport) nextTransport() (int, http.RoundTripper) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	transport := r.transports[r.index]
-	r.index = (r.index + 1) % len(r.transports)
-	return transport
+	now := time.Now()
+	index := r.index
+	for i := 0; i < len(r.transports); i++ {
+		candidate := (r.index + i) % len(r.transports)
+		if !now.Before(r.circuits[candidate].openUntil) {
+			index = candidate
+			break
+		}
+	}
+	r.index = (index + 1) % len(r.transports)
+	return index, r.transports[index]
 }
 
 func (r *RoundRobinTransport) isRetryable(code int) bool {
 	return r.retryable[code]
 }
 
+// shouldRetryError reports whether a failed attempt is retried on the next
+// proxy. Only throttling is, as if the proxy had returned status 429.
+func (r *RoundRobinTransport) shouldRetryError(err error) bool {
+	return ClassifyError(err) == ErrorClassThrottled && r.isRetryable(http.StatusTooManyRequests)
+}
+
 var defaultRetryableCodes = map[int]bool{
 	http.StatusTooManyRequests:    true,
 	http.StatusRequestTimeout:     true,
@@ -125,3 +238,29 @@ var defaultRetryableCodes = map[int]bool{
 	http.StatusBadGateway:         true,
 	999:                           true,
 }
+
+// isProxyFailure returns true if the error indicates the proxy itself failed,
+// as opposed to the request being invalid or canceled.
+func isProxyFailure(err error) bool {
+	if err == nil || errors.Is(err, ErrBudgetExceeded) {
+		return false
+	}
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		switch proxyErr.Type {
+		case ProxyErrBadRequest, ProxyErrExceededMaxBodySize, ProxyErrDisallowedContentType:
+			return false
+		}
+		return true
+	}
+	return ClassifyError(err) != ErrorClassCanceled
+}
+
+// proxyURLOf returns the proxy URL of a Burrow transport, or an empty string
+// for other http.RoundTripper implementations.
+func proxyURLOf(rt http.RoundTripper) string {
+	if t, ok := rt.(interface{ ProxyURL() string }); ok {
+		return t.ProxyURL()
+	}
+	return ""
+}
@@ -0,0 +1,249 @@
+package burrow
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is returned by a Transport when its UsageAccountant has
+// reached the configured spend ceiling.
+var ErrBudgetExceeded = errors.New("burrow: usage budget exceeded")
+
+// defaultMemoryMB matches the default memory size of the Terraform module.
+const defaultMemoryMB = 256
+
+// PriceTable contains the prices used to estimate the cost of proxy requests,
+// in USD.
+type PriceTable struct {
+	// PerRequest is the price of a single invocation
+	PerRequest float64
+	// PerGBSecond is the price of one GB-second of billed duration
+	PerGBSecond float64
+	// PerGBTransferOut is the price of one GB of data sent from the proxy to
+	// the client
+	PerGBTransferOut float64
+}
+
+// DefaultPriceTable contains on-demand AWS Lambda prices for x86_64
+// functions in the default-enabled regions.
+var DefaultPriceTable = PriceTable{
+	PerRequest:       0.0000002,
+	PerGBSecond:      0.0000166667,
+	PerGBTransferOut: 0.09,
+}
+
+// Usage contains aggregated usage for one proxy.
+type Usage struct {
+	Invocations    int64         `json:"invocations"`
+	Errors         int64         `json:"errors"`
+	BilledDuration time.Duration `json:"billed_duration"`
+	BytesSent      int64         `json:"bytes_sent"`
+	BytesReceived  int64         `json:"bytes_received"`
+	Cost           float64       `json:"cost"`
+}
+
+func (u *Usage) add(other *Usage) {
+	u.Invocations += other.Invocations
+	u.Errors += other.Errors
+	u.BilledDuration += other.BilledDuration
+	u.BytesSent += other.BytesSent
+	u.BytesReceived += other.BytesReceived
+	u.Cost += other.Cost
+}
+
+var _ Metrics = &UsageAccountant{}
+
+// UsageAccountant aggregates proxy invocations, estimated billed duration,
+// bytes transferred and estimated cost for each proxy. It can optionally
+// enforce a budget, after which transports reject requests with
+// ErrBudgetExceeded.
+//
+// Only requests that reached the proxy are counted. Billed duration is
+// estimated from the upstream duration reported by the proxy, rounded up to
+// the nearest millisecond. When the proxy replies with an error and no
+// duration, the client-side duration is used instead, which overestimates the
+// cost.
+type UsageAccountant struct {
+	mutex        sync.Mutex
+	memoryMB     int
+	defaultPrice PriceTable
+	regionPrices map[string]PriceTable
+	budget       float64
+	usage        map[string]*Usage
+	total        Usage
+	reserved     float64
+}
+
+// NewUsageAccountant creates a UsageAccountant using the default price table
+// and memory size.
+func NewUsageAccountant() *UsageAccountant {
+	return &UsageAccountant{
+		memoryMB:     defaultMemoryMB,
+		defaultPrice: DefaultPriceTable,
+		regionPrices: map[string]PriceTable{},
+		usage:        map[string]*Usage{},
+	}
+}
+
+// WithMemorySize sets the memory size of the proxy functions in MB.
+func (a *UsageAccountant) WithMemorySize(memoryMB int) *UsageAccountant {
+	a.memoryMB = memoryMB
+	return a
+}
+
+// WithPriceTable sets the price table used for regions without a specific
+// price table.
+func (a *UsageAccountant) WithPriceTable(prices PriceTable) *UsageAccountant {
+	a.defaultPrice = prices
+	return a
+}
+
+// WithRegionPrices sets region-specific price tables, keyed by AWS region.
+func (a *UsageAccountant) WithRegionPrices(prices map[string]PriceTable) *UsageAccountant {
+	a.regionPrices = prices
+	return a
+}
+
+// WithBudget sets the spend ceiling in USD. Once the estimated total cost,
+// including that of requests in flight, reaches the budget, transports reject
+// new requests. A budget of zero disables enforcement.
+func (a *UsageAccountant) WithBudget(budget float64) *UsageAccountant {
+	a.budget = budget
+	return a
+}
+
+// Check returns ErrBudgetExceeded if the budget has been reached.
+func (a *UsageAccountant) Check() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.budget > 0 && a.total.Cost >= a.budget {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+// reserve reserves the estimated cost of one request against the budget,
+// returning ErrBudgetExceeded if the budget would be reached. Reserving
+// holds the lock, so concurrent requests can't overshoot the budget by more
+// than the error in the estimate. The reservation must be released once the
+// request has been observed.
+func (a *UsageAccountant) reserve() (float64, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.budget <= 0 {
+		return 0, nil
+	}
+	if a.total.Cost+a.reserved >= a.budget {
+		return 0, ErrBudgetExceeded
+	}
+	// Estimate the cost from the average so far
+	estimate := a.defaultPrice.PerRequest
+	if a.total.Invocations > 0 {
+		estimate = a.total.Cost / float64(a.total.Invocations)
+	}
+	a.reserved += estimate
+	return estimate, nil
+}
+
+// release releases a reservation made by reserve.
+func (a *UsageAccountant) release(amount float64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.reserved = max(a.reserved-amount, 0)
+}
+
+// ObserveRequest implements the Metrics interface. Observations of requests
+// that didn't invoke the function are ignored, e.g. those that never reached
+// the proxy or were rejected by the platform. Requests that were sent but
+// not answered, e.g. because the client timed out, are billed for the time
+// the client waited.
+func (a *UsageAccountant) ObserveRequest(obs *RequestObservation) {
+	if !wasInvoked(obs.Err) {
+		return
+	}
+	duration := obs.UpstreamDuration
+	if duration == 0 && obs.Err != nil {
+		duration = obs.Duration
+	}
+	billed := time.Duration(math.Ceil(float64(duration)/float64(time.Millisecond))) * time.Millisecond
+	prices := a.priceTable(regionOf(obs.ProxyURL, obs.ProxyName))
+	usage := &Usage{
+		Invocations:    1,
+		BilledDuration: billed,
+		BytesSent:      obs.BytesSent,
+		BytesReceived:  obs.BytesReceived,
+	}
+	if obs.Err != nil {
+		usage.Errors = 1
+	}
+	gbSeconds := float64(a.memoryMB) / 1024 * billed.Seconds()
+	usage.Cost = prices.PerRequest +
+		prices.PerGBSecond*gbSeconds +
+		prices.PerGBTransferOut*float64(obs.BytesReceived)/(1<<30)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	proxyUsage, ok := a.usage[obs.ProxyURL]
+	if !ok {
+		proxyUsage = &Usage{}
+		a.usage[obs.ProxyURL] = proxyUsage
+	}
+	proxyUsage.add(usage)
+	a.total.add(usage)
+}
+
+// ObserveRetry implements the Metrics interface.
+func (a *UsageAccountant) ObserveRetry(proxyURL string, statusCode int) {}
+
+// Usage returns a copy of the usage for each proxy, keyed by proxy URL.
+func (a *UsageAccountant) Usage() map[string]Usage {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	usage := make(map[string]Usage, len(a.usage))
+	for proxyURL, u := range a.usage {
+		usage[proxyURL] = *u
+	}
+	return usage
+}
+
+// Total returns the usage aggregated across all proxies.
+func (a *UsageAccountant) Total() Usage {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.total
+}
+
+func (a *UsageAccountant) priceTable(region string) PriceTable {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if prices, ok := a.regionPrices[region]; ok {
+		return prices
+	}
+	return a.defaultPrice
+}
+
+// regionOf determines the AWS region of a proxy from its name (e.g.
+// "aws.lambda.us-east-1") or its Function URL host (e.g.
+// "id.lambda-url.us-east-1.on.aws").
+func regionOf(proxyURL, proxyName string) string {
+	if region, ok := strings.CutPrefix(proxyName, "aws.lambda."); ok {
+		return region
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) >= 3 && parts[1] == "lambda-url" {
+		return parts[2]
+	}
+	// Lambda Invoke API endpoints, e.g. lambda.us-east-1.amazonaws.com
+	if len(parts) >= 3 && parts[0] == "lambda" {
+		return parts[1]
+	}
+	return ""
+}
@@ -0,0 +1,187 @@
+package burrow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageAccountant_ObserveRequest(t *testing.T) {
+	accountant := NewUsageAccountant().
+		WithMemorySize(1024).
+		WithPriceTable(PriceTable{PerRequest: 1, PerGBSecond: 10}).
+		WithRegionPrices(map[string]PriceTable{"eu-west-2": {PerRequest: 2, PerGBSecond: 20}})
+
+	accountant.ObserveRequest(&RequestObservation{
+		ProxyURL:         "https://abc.lambda-url.us-east-1.on.aws/",
+		UpstreamDuration: 1500 * time.Microsecond,
+		BytesSent:        10,
+		BytesReceived:    20,
+	})
+	accountant.ObserveRequest(&RequestObservation{
+		ProxyURL:   "https://def.lambda-url.eu-west-2.on.aws/",
+		ProxyName:  "aws.lambda.eu-west-2",
+		Err:        ProxyErrorf(ProxyErrUnknown, "failed"),
+		ErrorClass: ErrorClassProxy,
+		Duration:   time.Second,
+	})
+	// The function was never invoked
+	accountant.ObserveRequest(&RequestObservation{
+		ProxyURL:   "https://def.lambda-url.eu-west-2.on.aws/",
+		Err:        errors.New("connection refused"),
+		ErrorClass: ErrorClassTransport,
+		Duration:   time.Second,
+	})
+
+	usage := accountant.Usage()
+	east := usage["https://abc.lambda-url.us-east-1.on.aws/"]
+	assert.Equal(t, int64(1), east.Invocations)
+	assert.Equal(t, 2*time.Millisecond, east.BilledDuration)
+	assert.Equal(t, int64(10), east.BytesSent)
+	assert.Equal(t, int64(20), east.BytesReceived)
+	assert.InDelta(t, 1+10*0.002, east.Cost, 1e-9)
+
+	west := usage["https://def.lambda-url.eu-west-2.on.aws/"]
+	assert.Equal(t, int64(1), west.Errors)
+	assert.Equal(t, time.Second, west.BilledDuration)
+	assert.InDelta(t, 2+20*1.0, west.Cost, 1e-9)
+
+	total := accountant.Total()
+	assert.Equal(t, int64(2), total.Invocations)
+	assert.InDelta(t, east.Cost+west.Cost, total.Cost, 1e-9)
+}
+
+func TestUsageAccountant_Budget(t *testing.T) {
+	var calls int
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		json.NewEncoder(w).Encode(Response{StatusCode: 200, Duration: 0.1})
+	}))
+	defer mockProxy.Close()
+
+	accountant := NewUsageAccountant().
+		WithPriceTable(PriceTable{PerRequest: 1}).
+		WithBudget(2)
+	client := NewClient(WithProxyURL(mockProxy.URL), WithUsageAccountant(accountant))
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("https://example.com")
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	_, err := client.Get("https://example.com")
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.Equal(t, 2, calls)
+}
+
+func TestUsageAccountant_BudgetConcurrent(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		json.NewEncoder(w).Encode(Response{StatusCode: 200})
+	}))
+	defer mockProxy.Close()
+
+	accountant := NewUsageAccountant().
+		WithPriceTable(PriceTable{PerRequest: 1}).
+		WithBudget(2)
+	client := NewClient(WithProxyURL(mockProxy.URL), WithUsageAccountant(accountant))
+
+	var wg sync.WaitGroup
+	var rejected atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get("https://example.com")
+			if errors.Is(err, ErrBudgetExceeded) {
+				rejected.Add(1)
+			} else if err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	// Wait for the rejected requests before letting the others finish
+	require.Eventually(t, func() bool { return rejected.Load() == 8 }, 5*time.Second, 10*time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), calls.Load())
+	assert.InDelta(t, 2, accountant.Total().Cost, 1e-9)
+}
+
+func TestUsageAccountant_NotInvoked(t *testing.T) {
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	mockProxy.Close()
+
+	accountant := NewUsageAccountant()
+	client := NewClient(WithProxyURL(mockProxy.URL), WithUsageAccountant(accountant))
+	_, err := client.Get("https://example.com")
+	require.Error(t, err)
+	assert.Equal(t, Usage{}, accountant.Total())
+}
+
+func TestUsageAccountant_InvokeRejected(t *testing.T) {
+	lambdaAPI := newLambdaAPI(t, func(ctx context.Context, req *Request) (*Response, error) {
+		return &Response{StatusCode: 200}, nil
+	})
+	defer lambdaAPI.Close()
+
+	// The Invoke API rejects the signature, so the function doesn't run
+	accountant := NewUsageAccountant()
+	transport := NewTransportWithBackend(
+		NewLambdaBackend("burrow", "us-east-1", StaticCredentials("AKID", "WRONG", "")).WithEndpoint(lambdaAPI.URL)).
+		WithUsageAccountant(accountant)
+	_, err := (&http.Client{Transport: transport}).Get("https://example.com")
+	require.ErrorContains(t, err, "lambda invoke returned status 403")
+	assert.Equal(t, ErrorClassProxy, ClassifyError(err))
+	assert.Equal(t, Usage{}, accountant.Total())
+}
+
+func TestUsageAccountant_ClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	mockProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer mockProxy.Close()
+	defer close(release)
+
+	// The function ran until the client gave up, so it is billed
+	accountant := NewUsageAccountant()
+	client := NewClient(WithProxyURL(mockProxy.URL), WithUsageAccountant(accountant))
+	client.Timeout = 50 * time.Millisecond
+	_, err := client.Get("https://example.com")
+	require.Error(t, err)
+	assert.Equal(t, ErrorClassCanceled, ClassifyError(err))
+	total := accountant.Total()
+	assert.Equal(t, int64(1), total.Invocations)
+	assert.Equal(t, int64(1), total.Errors)
+	assert.GreaterOrEqual(t, total.BilledDuration, 50*time.Millisecond)
+}
+
+func TestUsageAccountant_Throttled(t *testing.T) {
+	accountant := NewUsageAccountant()
+	accountant.ObserveRequest(&RequestObservation{
+		ProxyURL:   "https://abc.lambda-url.us-east-1.on.aws/",
+		Err:        ProxyErrorf(ProxyErrThrottled, "rate exceeded"),
+		ErrorClass: ErrorClassThrottled,
+		Duration:   10 * time.Millisecond,
+	})
+	assert.Equal(t, Usage{}, accountant.Total())
+}
+
+func TestRegionOf(t *testing.T) {
+	assert.Equal(t, "ap-south-1", regionOf("", "aws.lambda.ap-south-1"))
+	assert.Equal(t, "us-west-2", regionOf("https://abc.lambda-url.us-west-2.on.aws/", ""))
+	assert.Equal(t, "", regionOf("http://127.0.0.1:8080", ""))
+}